@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/batrSens/LispXS/interpreter"
+)
+
+// pathArgument matches the beginning of the path in a string given to load, import or require or
+// of the path of :load.
+var pathArgument = regexp.MustCompile(`(\((load|import|require)\s+"|^\s*:load\s+"?)([^"]*)$`)
+
+// completer returns the completion function of the line editor. Paths are completed inside strings given to
+// load, import and require, names of variables and builtin functions are completed elsewhere.
+func completer(interp *interpreter.Interpreter) func(head string) (string, []string) {
+	return func(head string) (string, []string) {
+		if m := pathArgument.FindStringSubmatch(head); m != nil {
+			return m[3], completePath(m[3])
+		}
+
+		if strings.Count(head, `"`)%2 == 1 {
+			return "", nil
+		}
+
+		start := strings.LastIndexAny(head, " \t()'`,\"") + 1
+		word := head[start:]
+		if word == "" {
+			return "", nil
+		}
+
+		return word, interp.Complete(word)
+	}
+}
+
+// completePath returns paths of files and directories starting with the prefix, directories end with a slash.
+func completePath(prefix string) []string {
+	dir, base := filepath.Split(prefix)
+	read := dir
+	if read == "" {
+		read = "."
+	}
+
+	files, err := ioutil.ReadDir(read)
+	if err != nil {
+		return nil
+	}
+
+	var res []string
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, base) || strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+
+		if file.IsDir() {
+			name += string(filepath.Separator)
+		}
+
+		res = append(res, dir+name)
+	}
+
+	return res
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/batrSens/LispXS/interpreter"
+	"github.com/batrSens/LispXS/parser"
+)
+
+// client is the connection to the REPL server.
+type client struct {
+	conn      net.Conn
+	writing   sync.Mutex
+	id        int64
+	responses chan *interpreter.Response
+}
+
+// connectCommand runs the REPL of the interpreter served by interpreter.ServeREPL. The address is a path of
+// the Unix socket or host:port. It returns the exit code of the process.
+func connectCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: lispxs connect address")
+		return 2
+	}
+
+	network := "tcp"
+	if strings.ContainsRune(args[0], os.PathSeparator) {
+		network = "unix"
+	}
+
+	conn, err := net.Dial(network, args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer conn.Close()
+
+	hello := &interpreter.Response{}
+	if err := interpreter.ReadFrame(conn, hello); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	c := &client{conn: conn, responses: make(chan *interpreter.Response)}
+	go c.receive()
+
+	stdin := bufio.NewReader(os.Stdin)
+	editor := newLineEditor(stdin, os.Stdout, int(os.Stdin.Fd()), historyFile())
+
+	// Ctrl-C interrupts the calculation of the session
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+
+	if isTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintf(os.Stderr, "connected to %s, session %d\n", args[0], hello.Session)
+	}
+
+	var src string
+	for {
+		prompt := "> "
+		if src != "" {
+			prompt = "... "
+		}
+
+		line, err := editor.readLine(prompt)
+		if err == errInterrupted {
+			src = ""
+			continue
+		} else if err == io.EOF {
+			return 0
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+
+		src += line + "\n"
+		if parser.Incomplete(src) {
+			continue
+		}
+
+		editor.addHistory(src)
+		code, exit := c.eval(src, signals)
+		if exit {
+			return code
+		}
+		src = ""
+	}
+}
+
+// receive passes responses to the channel, it's closed when the connection is closed.
+func (c *client) receive() {
+	defer close(c.responses)
+
+	for {
+		res := &interpreter.Response{}
+		if err := interpreter.ReadFrame(c.conn, res); err != nil {
+			return
+		}
+
+		c.responses <- res
+	}
+}
+
+func (c *client) send(op, code string, session int64) (int64, error) {
+	c.writing.Lock()
+	defer c.writing.Unlock()
+
+	c.id++
+	return c.id, interpreter.WriteFrame(c.conn, &interpreter.Request{ID: c.id, Op: op, Code: code, Session: session})
+}
+
+// eval sends the program and prints the response, Ctrl-C interrupts it. :interrupt session interrupts
+// the calculation of another session. It returns true with the exit code if the connection is finished.
+func (c *client) eval(src string, signals chan os.Signal) (int, bool) {
+	fields := strings.Fields(src)
+	if len(fields) == 0 {
+		return 0, false
+	} else if fields[0] == ":quit" {
+		return 0, true
+	}
+
+	op, session := interpreter.OpEval, int64(0)
+	if fields[0] == ":interrupt" {
+		if len(fields) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: :interrupt session")
+			return 0, false
+		}
+
+		var err error
+		if session, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+			fmt.Fprintln(os.Stderr, ":interrupt:", err)
+			return 0, false
+		}
+		op = interpreter.OpInterrupt
+	}
+
+	// Ctrl-C pressed before the calculation doesn't interrupt it
+	select {
+	case <-signals:
+	default:
+	}
+
+	id, err := c.send(op, src, session)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2, true
+	}
+
+	for {
+		select {
+		case <-signals:
+			_, _ = c.send(interpreter.OpInterrupt, "", 0)
+		case res, ok := <-c.responses:
+			if !ok {
+				fmt.Fprintln(os.Stderr, "connection is closed")
+				return 0, true
+			}
+
+			if res.ID == id {
+				return printResponse(res)
+			}
+		}
+	}
+}
+
+// printResponse prints output of the calculation and its result. It returns true with the exit code if
+// the calculation is stopped by exit.
+func printResponse(res *interpreter.Response) (int, bool) {
+	fmt.Print(res.Stdout)
+	fmt.Fprint(os.Stderr, res.Stderr)
+
+	switch {
+	case res.Error != "":
+		fmt.Fprintln(os.Stderr, res.Error)
+	case res.Fatal != nil && res.Fatal.Exit:
+		code, err := strconv.Atoi(res.Fatal.Payload)
+		if err != nil {
+			code = 1
+		}
+		return code, true
+	case res.Fatal != nil && res.Fatal.Tag == interpreter.InterruptTag:
+		fmt.Fprintln(os.Stderr, "\nFATAL: "+interpreter.InterruptTag)
+	case res.Result != nil:
+		fmt.Println(*res.Result)
+	}
+
+	return 0, false
+}
@@ -3,6 +3,10 @@ package expressions
 import (
 	"fmt"
 	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/batrSens/LispXS/lexer"
 )
 
 const (
@@ -15,8 +19,26 @@ const (
 	Macro
 	Number
 	Nil
+	Port
 )
 
+var typeNames = map[int]string{
+	Symbol:   "symbol",
+	Pair:     "pair",
+	Fatal:    "fatal",
+	Function: "function",
+	Closure:  "closure",
+	Macro:    "macro",
+	Number:   "number",
+	Nil:      "nil",
+	Port:     "port",
+}
+
+// TypeName returns the name of the expression's type.
+func (e *Expr) TypeName() string {
+	return typeNames[e.Type]
+}
+
 type ExprError struct {
 	message string
 }
@@ -52,27 +74,27 @@ func (v *Vars) IsRoot() bool {
 	return v.Parent == nil
 }
 
-func varsDebug(vars map[string]*Expr) string {
-	res := "( "
-	for k, _ := range vars {
-		res += k + /*" : " + v.ToString() +*/ " "
+func (v *Vars) Lookup(name string) (*Expr, bool) {
+	for cur := v; cur != nil; cur = cur.Parent {
+		if expr, ok := cur.CurSymbols[name]; ok {
+			return expr, true
+		}
 	}
-	return res + ")"
-}
 
-func (v *Vars) Debug() {
-	str := fmt.Sprintf("%s ", varsDebug(v.CurSymbols))
-	cur := v.Parent
-	for cur != nil {
-		str += fmt.Sprintf("-> %s ", varsDebug(cur.CurSymbols))
-		cur = cur.Parent
-	}
-	fmt.Println(str)
+	return nil, false
 }
 
+const (
+	paramRequired = iota
+	paramOptional
+	paramKey
+)
+
 type variable struct {
 	name               string
 	calculatedForMacro bool
+	kind               int
+	def                *Expr
 }
 
 type closureVars struct {
@@ -94,6 +116,14 @@ type Expr struct {
 
 	Vars       closureVars
 	ParentVars *Vars
+	Rules      *Expr
+	Doc        string
+	Stream     *Stream
+	expanded   bool
+	source     *Expr // body of the closure before expansion of macros
+	literal    bool  // symbol is written as a string literal, so it may be a docstring
+	uninterned bool  // symbol is created by NewUninternedSymbol, it is equal only to itself
+	exit       bool  // fatal is created by NewExit, it stops the program with the exit code
 	stackTrace []struct {
 		f   *Expr
 		pos int
@@ -110,10 +140,13 @@ func (e *Expr) DebugString() string {
 		return fmt.Sprintf("Fatal(%s)", e.String)
 	case Function:
 		return fmt.Sprintf("Function(%s)", e.String)
-	case Closure:
-		return "Closure" + fmt.Sprintf("%v", e.Vars.vars) + e.cdr.ToString()
-	case Macro:
-		return "Macro" + fmt.Sprintf("%v", e.Vars.vars) + e.cdr.ToString()
+	case Closure, Macro:
+		if e.String != "" {
+			return e.ToString()
+		}
+		return e.ToString() + e.cdr.ToString()
+	case Port:
+		return "Port(" + e.Stream.Name + ")"
 	case Nil:
 		return "Nil"
 	case Pair:
@@ -134,9 +167,11 @@ func (e *Expr) ToString() string {
 	case Function:
 		return fmt.Sprintf("Function(%s)", e.String)
 	case Closure:
-		return "Closure" + fmt.Sprintf("%v", e.Vars.vars)
+		return procedureString("Closure", e)
 	case Macro:
-		return "Macro" + fmt.Sprintf("%v", e.Vars.vars)
+		return procedureString("Macro", e)
+	case Port:
+		return "#<port " + e.Stream.Name + ">"
 	case Nil:
 		return "nil"
 	case Pair:
@@ -157,14 +192,125 @@ func (e *Expr) ToString() string {
 	}
 }
 
+// ReadableString returns representation of the expression which is read back to the same expression:
+// symbols are quoted by '|' when it is needed and nil is written as an empty list.
+func (e *Expr) ReadableString() string {
+	res := e.readableString()
+	if e.CalculatedForMacro {
+		return "," + res
+	}
+
+	return res
+}
+
+func (e *Expr) readableString() string {
+	switch e.Type {
+	case Symbol:
+		if e.IsUninterned() {
+			return e.ToString()
+		}
+
+		return symbolString(e.String)
+	case Nil:
+		return "()"
+	case Pair:
+		if e.car.Type == Function && e.car.String == "quote" && e.cdr.Type == Pair && e.cdr.cdr.Type == Nil {
+			return "'" + e.cdr.car.ReadableString()
+		}
+
+		var items []string
+		for cur := e; cur.Type == Pair; cur = cur.cdr {
+			items = append(items, cur.car.ReadableString())
+		}
+
+		return "(" + strings.Join(items, " ") + ")"
+	default:
+		return e.ToString()
+	}
+}
+
+func symbolString(name string) string {
+	lx := lexer.NewLexer(name)
+	tok, err := lx.NextToken()
+
+	// ';' and '|' are read as a part of the symbol in its middle, but they are quoted to be read by other tools
+	if err == nil && tok.Tag == lexer.TagSymbol && tok.String == name && !strings.ContainsAny(name, ";|") {
+		if next, err := lx.NextToken(); err == nil && next.Tag == lexer.TagEOF {
+			return name
+		}
+	}
+
+	var res strings.Builder
+	res.WriteByte('|')
+	for _, c := range name {
+		switch c {
+		case '|', '\\':
+			res.WriteRune('\\')
+			res.WriteRune(c)
+		case '\n':
+			res.WriteString("\\n")
+		case '\t':
+			res.WriteString("\\t")
+		default:
+			res.WriteRune(c)
+		}
+	}
+	res.WriteByte('|')
+
+	return res.String()
+}
+
+func procedureString(kind string, e *Expr) string {
+	if e.String == "" {
+		return kind + "(" + e.ParamsList().ToString() + ")"
+	}
+	return kind + "(" + e.String + " " + e.ParamsList().ToString() + ")"
+}
+
+// traceWidth is the width of bodies of closures in stack traces.
+const traceWidth = 80
+
+// StackTrace returns the tag of the error, its value if it's given and frames of the stack trace.
 func (e *Expr) StackTrace() string {
 	res := "FATAL: " + e.String + "\n"
+	if e.Res != nil && !e.Res.IsNil() {
+		res += indented(Pretty(e.Res, traceWidth-2)) + "\n"
+	}
+
+	for _, frame := range e.Trace() {
+		res += frame + "\n"
+	}
+	return res
+}
+
+// Trace returns frames of the stack trace of the error from the innermost one.
+func (e *Expr) Trace() []string {
+	var res []string
 	for _, st := range e.stackTrace {
-		res += st.f.DebugString() + " [" + strconv.Itoa(st.pos) + "]\n"
+		pos := " [" + strconv.Itoa(st.pos) + "]"
+		frame := st.f.DebugString() + pos
+
+		switch {
+		case (st.f.Type == Closure || st.f.Type == Macro) && st.f.String == "":
+			// the body is printed to find the closure which has no name
+			frame = st.f.ToString() + pos
+			for cur := st.f.cdr; cur.Type == Pair; cur = cur.cdr {
+				frame += "\n" + indented(Pretty(cur.car, traceWidth-2))
+			}
+		case st.f.Type == Pair:
+			frame = "List" + pos + "\n" + indented(Pretty(st.f, traceWidth-2))
+		}
+
+		res = append(res, frame)
 	}
 	return res
 }
 
+// indented shifts lines of the string by 2 spaces.
+func indented(str string) string {
+	return "  " + strings.Replace(str, "\n", "\n  ", -1)
+}
+
 func NewSymbol(name string) *Expr {
 	return &Expr{
 		Type:   Symbol,
@@ -172,6 +318,30 @@ func NewSymbol(name string) *Expr {
 	}
 }
 
+// NewString returns the string literal "value": the quoted symbol, which is calculated to itself. Only such
+// literals are docstrings.
+func NewString(value string) *Expr {
+	sym := NewSymbol(value)
+	sym.literal = true
+	return NewFunction("quote").Cons(sym.ToList())
+}
+
+// uninterned numbers uninterned symbols of all interpreters.
+var uninterned int64
+
+// NewUninternedSymbol returns a symbol which is equal only to itself, it is written as #:prefix with a number.
+func NewUninternedSymbol(prefix string) *Expr {
+	n := atomic.AddInt64(&uninterned, 1)
+	res := NewSymbol("#:" + prefix + strconv.FormatInt(n, 10))
+	res.uninterned = true
+	return res
+}
+
+// IsUninterned reports whether the expression is a symbol created by NewUninternedSymbol.
+func (e *Expr) IsUninterned() bool {
+	return e.Type == Symbol && e.uninterned
+}
+
 func NewFatal(tag string, res ...*Expr) *Expr {
 	fat := &Expr{
 		Type:   Fatal,
@@ -195,6 +365,19 @@ func NewFatal(tag string, res ...*Expr) *Expr {
 	return fat
 }
 
+// NewExit returns the fatal by which the program is stopped with the exit code. Fatals thrown by the program
+// with the same tag aren't exits.
+func NewExit(tag string, code *Expr) *Expr {
+	res := NewFatal(tag, code)
+	res.exit = true
+	return res
+}
+
+// IsExit reports whether the expression is a fatal created by NewExit.
+func (e *Expr) IsExit() bool {
+	return e.Type == Fatal && e.exit
+}
+
 func NewFunction(name string) *Expr {
 	return &Expr{
 		Type:   Function,
@@ -219,18 +402,49 @@ func NewClosure(args *Expr, body []*Expr, parentVars *Vars) *Expr {
 			vars:           []variable{{name: args.String}},
 		}
 	} else {
-		for !args.IsNil() {
-			if args.Car().Type != Symbol {
+		kind := paramRequired
+		for ; !args.IsNil(); args = args.Cdr() {
+			arg := args.Car()
+
+			if arg.Type == Symbol && arg.String == "#!optional" {
+				if kind != paramRequired {
+					return NewFatal("lambda: #!optional must be given once before #:key")
+				}
+
+				kind = paramOptional
+				continue
+			}
+
+			if arg.Type == Symbol && arg.String == "#:key" {
+				if kind == paramKey {
+					return NewFatal("lambda: #:key must be given once")
+				}
+
+				kind = paramKey
+				continue
+			}
+
+			v := variable{name: arg.String, kind: kind}
+			if arg.Type == Pair {
+				if kind == paramRequired {
+					return NewFatal("lambda: default value is allowed only after #!optional or #:key")
+				}
+
+				if arg.Car().Type != Symbol || arg.Length() != 2 {
+					return NewFatal("lambda: arg with default value must be a list of symbol and expression, given " + arg.ToString())
+				}
+
+				v = variable{name: arg.Car().String, kind: kind, def: arg.Cdr().Car()}
+			} else if arg.Type != Symbol {
 				return NewFatal("lambda: all args must be a symbols")
 			}
 
-			if _, ok := exists[args.Car().String]; ok {
+			if _, ok := exists[v.name]; ok {
 				return NewFatal("lambda: all args must be a different")
 			}
 
-			exists[args.Car().String] = struct{}{}
-			vars.vars = append(vars.vars, variable{name: args.Car().String})
-			args = args.Cdr()
+			exists[v.name] = struct{}{}
+			vars.vars = append(vars.vars, v)
 		}
 	}
 
@@ -238,6 +452,13 @@ func NewClosure(args *Expr, body []*Expr, parentVars *Vars) *Expr {
 		return NewFatal("lambda: nil body")
 	}
 
+	// string followed by other expressions is a docstring
+	doc := ""
+	if str, ok := StringValue(body[0]); ok && len(body) > 1 {
+		doc = str
+		body = body[1:]
+	}
+
 	lambdaBody := NewNil()
 	for i := len(body) - 1; i >= 0; i-- {
 		lambdaBody = body[i].Cons(lambdaBody)
@@ -249,6 +470,7 @@ func NewClosure(args *Expr, body []*Expr, parentVars *Vars) *Expr {
 		cdr:        lambdaBody,
 		Vars:       vars,
 		ParentVars: parentVars,
+		Doc:        doc,
 	}
 }
 
@@ -288,6 +510,13 @@ func NewMacro(args *Expr, body []*Expr, parentVars *Vars) *Expr {
 		return NewFatal("defmacro: nil body")
 	}
 
+	// string followed by other expressions is a docstring
+	doc := ""
+	if str, ok := StringValue(body[0]); ok && len(body) > 1 {
+		doc = str
+		body = body[1:]
+	}
+
 	lambdaBody := NewNil()
 	for i := len(body) - 1; i >= 0; i-- {
 		lambdaBody = body[i].Cons(lambdaBody)
@@ -299,6 +528,35 @@ func NewMacro(args *Expr, body []*Expr, parentVars *Vars) *Expr {
 		cdr:        lambdaBody,
 		Vars:       vars,
 		ParentVars: parentVars,
+		Doc:        doc,
+	}
+}
+
+// NewSyntaxRules returns macro that expands by patterns. Rules is a list: first element is list of literals,
+// other ones are lists of pattern and template.
+func NewSyntaxRules(rules *Expr, parentVars *Vars) *Expr {
+	if rules.Type != Pair {
+		return NewFatal("syntax-rules: expected list of literals")
+	}
+
+	for cur := rules.Car(); !cur.IsNil(); cur = cur.Cdr() {
+		if cur.Type != Pair || cur.Car().Type != Symbol {
+			return NewFatal("syntax-rules: literals must be a list of symbols")
+		}
+	}
+
+	for cur := rules.Cdr(); !cur.IsNil(); cur = cur.Cdr() {
+		rule := cur.Car()
+		if rule.Type != Pair || rule.Car().Type != Pair || rule.Length() != 2 {
+			return NewFatal("syntax-rules: rule must be a list of pattern and template, given " + rule.ToString())
+		}
+	}
+
+	return &Expr{
+		Type:       Macro,
+		Vars:       closureVars{variableNumber: true, vars: []variable{{name: "form"}}},
+		ParentVars: parentVars,
+		Rules:      rules,
 	}
 }
 
@@ -320,6 +578,8 @@ func (e *Expr) MacroExecMod() map[int]struct{} {
 	return res
 }
 
+// NewClosureVars binds arguments of the call with parameters. Optional and keyword parameters that have default
+// values and aren't given stay unbound, see Defaults.
 func (e *Expr) NewClosureVars(args []*Expr) (*Vars, error) {
 	vars := NewRootVars()
 	vars.Parent = e.ParentVars
@@ -335,23 +595,227 @@ func (e *Expr) NewClosureVars(args []*Expr) (*Vars, error) {
 		}
 
 		vars.CurSymbols[e.Vars.vars[0].name] = argsList
-	} else {
-		if len(e.Vars.vars) != len(args) {
-			return nil, NewExprError(fmt.Sprintf("call: expected %d args, got %d args", len(e.Vars.vars), len(args)))
+		return vars, nil
+	}
+
+	var positional, keys []variable
+	required := 0
+	for _, v := range e.Vars.vars {
+		if v.kind == paramKey {
+			keys = append(keys, v)
+			continue
+		}
+
+		if v.kind == paramRequired {
+			required++
+		}
+
+		positional = append(positional, v)
+	}
+
+	keyArgs := args[len(args):]
+	if len(keys) > 0 {
+		for i, arg := range args {
+			if IsKeyword(arg) {
+				args, keyArgs = args[:i], args[i:]
+				break
+			}
 		}
+	}
+
+	arity := strconv.Itoa(required)
+	if len(positional) > required {
+		arity = fmt.Sprintf("from %d to %d", required, len(positional))
+	}
+
+	if len(args) < required {
+		return nil, NewExprError(fmt.Sprintf("call: missing argument '%s', expected %s args, got %d args",
+			positional[len(args)].name, arity, len(args)))
+	}
 
-		for i, v := range e.Vars.vars {
+	if len(args) > len(positional) {
+		return nil, NewExprError(fmt.Sprintf("call: too many arguments, expected %s args, got %d args", arity, len(args)))
+	}
+
+	for i, v := range positional {
+		if i < len(args) {
 			vars.CurSymbols[v.name] = args[i]
+		} else if v.def == nil {
+			vars.CurSymbols[v.name] = NewNil()
+		}
+	}
+
+	given := map[string]struct{}{}
+	for i := 0; i < len(keyArgs); i += 2 {
+		if !IsKeyword(keyArgs[i]) {
+			return nil, NewExprError("call: expected keyword, given " + keyArgs[i].ToString())
+		}
+
+		name := keyArgs[i].String[1:]
+		if _, ok := given[name]; ok {
+			return nil, NewExprError(fmt.Sprintf("call: keyword argument '%s' is given twice", keyArgs[i].String))
+		}
+
+		known := false
+		for _, v := range keys {
+			known = known || v.name == name
+		}
+
+		if !known {
+			return nil, NewExprError(fmt.Sprintf("call: unknown keyword argument '%s'", keyArgs[i].String))
+		}
+
+		if i+1 == len(keyArgs) {
+			return nil, NewExprError(fmt.Sprintf("call: missing value of keyword argument '%s'", keyArgs[i].String))
+		}
+
+		given[name] = struct{}{}
+		vars.CurSymbols[name] = keyArgs[i+1]
+	}
+
+	for _, v := range keys {
+		if _, ok := given[v.name]; !ok && v.def == nil {
+			vars.CurSymbols[v.name] = NewNil()
 		}
 	}
 
 	return vars, nil
 }
 
+// Defaults returns names and default values of optional and keyword parameters in order of declaration.
+func (e *Expr) Defaults() ([]string, []*Expr) {
+	var names []string
+	var values []*Expr
+	for _, v := range e.Vars.vars {
+		if v.def != nil {
+			names = append(names, v.name)
+			values = append(values, v.def)
+		}
+	}
+
+	return names, values
+}
+
+// StringValue returns the value of the string literal created by NewString. Other quoted symbols aren't strings.
+func StringValue(e *Expr) (string, bool) {
+	if e.Type != Pair || e.car.Type != Function || e.car.String != "quote" || e.cdr.Type != Pair || !e.cdr.cdr.IsNil() {
+		return "", false
+	}
+
+	if e.cdr.car.Type != Symbol || !e.cdr.car.literal {
+		return "", false
+	}
+
+	return e.cdr.car.String, true
+}
+
+// IsKeyword reports whether e is a keyword: a symbol which starts with a colon. Keywords are calculated to themselves.
+func IsKeyword(e *Expr) bool {
+	return e.Type == Symbol && len(e.String) > 1 && e.String[0] == ':'
+}
+
 func (e *Expr) ClosureBody() *Expr {
 	return e.car.Cons(e.cdr)
 }
 
+// SetClosureBody replaces the body of the closure by the body with expanded macros.
+func (e *Expr) SetClosureBody(body *Expr) {
+	if !e.expanded {
+		e.source = e.cdr
+	}
+	e.cdr = body
+	e.expanded = true
+}
+
+func (e *Expr) IsExpanded() bool {
+	return e.expanded
+}
+
+// ParamsList returns parameters of the closure as they are written in lambda.
+func (e *Expr) ParamsList() *Expr {
+	if e.Rules != nil {
+		return NewSymbol("form")
+	}
+
+	if e.Vars.variableNumber {
+		return paramSymbol(e.Vars.vars[0])
+	}
+
+	var params []*Expr
+	kind := paramRequired
+	for _, v := range e.Vars.vars {
+		if v.kind != kind {
+			kind = v.kind
+			if kind == paramOptional {
+				params = append(params, NewSymbol("#!optional"))
+			} else {
+				params = append(params, NewSymbol("#:key"))
+			}
+		}
+
+		if v.def != nil {
+			params = append(params, paramSymbol(v).Cons(v.def.ToList()))
+		} else {
+			params = append(params, paramSymbol(v))
+		}
+	}
+
+	res := NewNil()
+	for i := len(params) - 1; i >= 0; i-- {
+		res = params[i].Cons(res)
+	}
+
+	return res
+}
+
+func paramSymbol(v variable) *Expr {
+	if v.calculatedForMacro {
+		return NewSymbol("," + v.name)
+	}
+	return NewSymbol(v.name)
+}
+
+// Arity returns the minimal number of positional arguments of the closure and the maximal one, which is -1
+// if the number is unlimited.
+func (e *Expr) Arity() (int, int) {
+	if e.Vars.variableNumber {
+		return 0, -1
+	}
+
+	min, max := 0, 0
+	for _, v := range e.Vars.vars {
+		switch v.kind {
+		case paramRequired:
+			min++
+			max++
+		case paramOptional:
+			max++
+		}
+	}
+
+	return min, max
+}
+
+// Body returns list of expressions of the closure's body as it is written.
+func (e *Expr) Body() *Expr {
+	if e.source != nil {
+		return e.source
+	}
+	if e.cdr == nil {
+		return NewNil()
+	}
+	return e.cdr
+}
+
+func (e *Expr) Params() []string {
+	res := make([]string, len(e.Vars.vars))
+	for i, v := range e.Vars.vars {
+		res[i] = v.name
+	}
+
+	return res
+}
+
 func NewNumber(num float64) *Expr {
 	return &Expr{
 		Type:   Number,
@@ -413,6 +877,10 @@ func (e *Expr) Equal(e1 *Expr) bool {
 		return false
 	}
 
+	if e.Type == Port || e.uninterned || e1.uninterned {
+		return e == e1
+	}
+
 	return e.Type == e1.Type && (e.Type == Fatal || e.String == e1.String && e.Number == e1.Number && e.car.Equal(e1.car) && e.cdr.Equal(e1.cdr))
 }
 
@@ -437,6 +905,10 @@ func (e *Expr) Length() int {
 }
 
 func (e *Expr) Index(i int) *Expr {
+	return e.Tail(i).car
+}
+
+func (e *Expr) Tail(i int) *Expr {
 	cur := e
 
 	for i > 0 {
@@ -444,5 +916,5 @@ func (e *Expr) Index(i int) *Expr {
 		cur = cur.cdr
 	}
 
-	return cur.car
+	return cur
 }
@@ -0,0 +1,178 @@
+package expressions
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// bodyForms are the numbers of arguments of special forms which are kept on the line with the form's name,
+// the rest of arguments is the body indented by 2 spaces.
+var bodyForms = map[string]int{
+	"begin":         0,
+	"cond":          0,
+	"case":          1,
+	"define":        1,
+	"define-syntax": 1,
+	"defmacro":      2,
+	"do":            2,
+	"for":           1,
+	"lambda":        1,
+	"let":           1,
+	"let*":          1,
+	"letrec":        1,
+	"module":        2,
+	"syntax-rules":  1,
+	"unless":        1,
+	"when":          1,
+	"while":         1,
+}
+
+// BodyArgs returns the number of arguments of the special form which are kept on the line with its name.
+func BodyArgs(name string) (int, bool) {
+	n, ok := bodyForms[name]
+	return n, ok
+}
+
+// Pretty returns representation of the expression as ReadableString does, but lists which don't fit into
+// width are broken into lines. Arguments of calls are aligned with the first one and bodies of special forms
+// are indented.
+//
+// The layout is greedy rather than Wadler's or Oppen's: a list is written on one line if it fits into the rest
+// of the current line, otherwise it is broken and its items are laid out the same way one by one. The printer
+// doesn't look ahead, so a list may be broken when breaking one of its items would be enough.
+func Pretty(e *Expr, width int) string {
+	p := &printer{width: width, widths: map[*Expr]int{}}
+	p.print(e, 0)
+	return p.res.String()
+}
+
+type printer struct {
+	res    strings.Builder
+	width  int
+	widths map[*Expr]int // flat widths of lists
+}
+
+// print writes the expression that starts at column col and returns column of its end.
+func (p *printer) print(e *Expr, col int) int {
+	if e.Type != Pair || col+p.flatWidth(e) <= p.width {
+		return p.write(e.ReadableString(), col)
+	}
+
+	if e.CalculatedForMacro {
+		col = p.write(",", col)
+	}
+
+	if e.car.Type == Function && e.car.String == "quote" && e.cdr.Type == Pair && e.cdr.cdr.Type == Nil {
+		return p.print(e.cdr.car, p.write("'", col))
+	}
+
+	var items []*Expr
+	for cur := e; cur.Type == Pair; cur = cur.cdr {
+		items = append(items, cur.car)
+	}
+
+	head := items[0]
+	start := col
+	col = p.write("(", col)
+
+	if head.Type != Symbol || head.CalculatedForMacro {
+		// data list, all items are aligned
+		return p.write(")", p.lines(items, col, col))
+	}
+
+	n, ok := bodyForms[head.String]
+	if ok && head.String == "let" && len(items) > 1 && items[1].Type == Symbol {
+		// named let
+		n++
+	}
+
+	if head.String == "if" && len(items) > 1 {
+		// the test is kept on the line with if, branches are aligned with it
+		col = p.write("if ", col)
+		return p.write(")", p.lines(items[1:], col, col))
+	}
+
+	if !ok {
+		// call, arguments are aligned with the first one if the name isn't too long
+		col = p.write(head.ReadableString(), col)
+		if len(items) == 1 {
+			return p.write(")", col)
+		}
+
+		if col+1 > start+p.width/3 {
+			return p.write(")", p.lines(items[1:], start+2, p.newline(start+2)))
+		}
+
+		col = p.write(" ", col)
+		return p.write(")", p.lines(items[1:], col, col))
+	}
+
+	if n > len(items)-1 {
+		n = len(items) - 1
+	}
+
+	for i, item := range items[:n+1] {
+		if i > 0 {
+			col = p.write(" ", col)
+		}
+		col = p.print(item, col)
+	}
+
+	if rest := items[n+1:]; len(rest) > 0 {
+		col = p.lines(rest, start+2, p.newline(start+2))
+	}
+
+	return p.write(")", col)
+}
+
+// flatWidth returns the length of ReadableString of the expression. Widths of lists are computed from widths
+// of their items and remembered, so every list is measured once however deep it is nested.
+func (p *printer) flatWidth(e *Expr) int {
+	if e.Type != Pair {
+		return utf8.RuneCountInString(e.ReadableString())
+	}
+
+	if w, ok := p.widths[e]; ok {
+		return w
+	}
+
+	w := 0
+	if e.CalculatedForMacro {
+		w++
+	}
+
+	if e.car.Type == Function && e.car.String == "quote" && e.cdr.Type == Pair && e.cdr.cdr.Type == Nil {
+		w += 1 + p.flatWidth(e.cdr.car)
+	} else {
+		// parentheses and spaces between items
+		w++
+		for cur := e; cur.Type == Pair; cur = cur.cdr {
+			w += p.flatWidth(cur.car) + 1
+		}
+	}
+
+	p.widths[e] = w
+	return w
+}
+
+// lines writes items one per line aligned at column indent, the first item starts at column col.
+func (p *printer) lines(items []*Expr, indent, col int) int {
+	for i, item := range items {
+		if i > 0 {
+			col = p.newline(indent)
+		}
+		col = p.print(item, col)
+	}
+
+	return col
+}
+
+func (p *printer) newline(indent int) int {
+	p.res.WriteByte('\n')
+	return p.write(strings.Repeat(" ", indent), 0)
+}
+
+func (p *printer) write(str string, col int) int {
+	p.res.WriteString(str)
+	return col + utf8.RuneCountInString(str)
+}
@@ -0,0 +1,109 @@
+package expressions
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+var ErrStreamClosed = errors.New("port is closed")
+
+// Stream is a source or a destination of characters.
+type Stream struct {
+	Name   string
+	reader *bufio.Reader
+	writer io.Writer
+	buffer *bytes.Buffer
+	closer io.Closer
+	closed bool
+}
+
+// NewInputStream returns stream that reads from r. Closer is called when the stream is closed, it may be nil.
+func NewInputStream(name string, r io.Reader, closer io.Closer) *Stream {
+	reader, ok := r.(*bufio.Reader)
+	if !ok {
+		reader = bufio.NewReader(r)
+	}
+
+	return &Stream{Name: name, reader: reader, closer: closer}
+}
+
+// NewOutputStream returns stream that writes to w. Closer is called when the stream is closed, it may be nil.
+func NewOutputStream(name string, w io.Writer, closer io.Closer) *Stream {
+	return &Stream{Name: name, writer: w, closer: closer}
+}
+
+// NewStringStream returns stream that collects written characters.
+func NewStringStream() *Stream {
+	buffer := &bytes.Buffer{}
+	return &Stream{Name: "string", writer: buffer, buffer: buffer}
+}
+
+func (s *Stream) IsInput() bool {
+	return s.reader != nil
+}
+
+func (s *Stream) IsOutput() bool {
+	return s.writer != nil
+}
+
+func (s *Stream) IsClosed() bool {
+	return s.closed
+}
+
+func (s *Stream) Reader() (*bufio.Reader, error) {
+	if s.closed {
+		return nil, ErrStreamClosed
+	}
+
+	if s.reader == nil {
+		return nil, errors.New("port " + s.Name + " isn't an input port")
+	}
+
+	return s.reader, nil
+}
+
+func (s *Stream) Write(str string) error {
+	if s.closed {
+		return ErrStreamClosed
+	}
+
+	if s.writer == nil {
+		return errors.New("port " + s.Name + " isn't an output port")
+	}
+
+	_, err := io.WriteString(s.writer, str)
+	return err
+}
+
+// OutputString returns characters written to the string stream.
+func (s *Stream) OutputString() (string, error) {
+	if s.buffer == nil {
+		return "", errors.New("port " + s.Name + " isn't a string output port")
+	}
+
+	return s.buffer.String(), nil
+}
+
+// Close closes the stream. Closing of closed stream does nothing.
+func (s *Stream) Close() error {
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+	if s.closer != nil {
+		return s.closer.Close()
+	}
+
+	return nil
+}
+
+// NewPort returns port expression of the stream.
+func NewPort(stream *Stream) *Expr {
+	return &Expr{
+		Type:   Port,
+		Stream: stream,
+	}
+}
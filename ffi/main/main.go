@@ -66,6 +66,11 @@ func library_call(lib, call unsafe.Pointer) (expr unsafe.Pointer, error *C.char)
 	return cExprAlloc(res), cNil()
 }
 
+//export library_close
+func library_close(lib unsafe.Pointer) {
+	goLib(lib).Close()
+}
+
 //export call_new
 func call_new(fn *C.char) unsafe.Pointer {
 	return cCallAlloc(&call{fn: C.GoString(fn), args: []interface{}{}})
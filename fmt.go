@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/batrSens/LispXS/formatter"
+)
+
+// sourceExts are extensions of files formatted in directories.
+var sourceExts = map[string]struct{}{".lxs": {}, ".lisp": {}}
+
+// fmtCommand formats files in place like gofmt -w, or only lists or shows the files which formatting differs.
+func fmtCommand(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	list := flags.Bool("l", false, "list files whose formatting differs, don't rewrite them")
+	diff := flags.Bool("d", false, "display diffs instead of rewriting files")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lispxs fmt [-l] [-d] [path ...]")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+
+	opts := fmtOptions{list: *list, diff: *diff, out: os.Stdout}
+
+	if flags.NArg() == 0 {
+		if err := opts.process("<standard input>", os.Stdin); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		return 0
+	}
+
+	code := 0
+	for _, path := range flags.Args() {
+		err := filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if _, ok := sourceExts[filepath.Ext(name)]; info.IsDir() || name != path && !ok {
+				return nil
+			}
+
+			if err := opts.processFile(name, info.Mode()); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				code = 2
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 2
+		}
+	}
+
+	return code
+}
+
+type fmtOptions struct {
+	list, diff bool
+	out        io.Writer
+}
+
+func (o fmtOptions) processFile(name string, mode os.FileMode) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	src, res, err := format(name, file)
+	if err != nil || bytes.Equal(src, res) {
+		return err
+	}
+
+	if o.list {
+		fmt.Fprintln(o.out, name)
+	}
+
+	if o.diff {
+		fmt.Fprint(o.out, unifiedDiff(name, string(src), string(res)))
+	}
+
+	if o.list || o.diff {
+		return nil
+	}
+
+	return ioutil.WriteFile(name, res, mode.Perm())
+}
+
+// process formats the standard input to the output.
+func (o fmtOptions) process(name string, in io.Reader) error {
+	src, res, err := format(name, in)
+	if err != nil {
+		return err
+	}
+
+	if o.list && !bytes.Equal(src, res) {
+		fmt.Fprintln(o.out, name)
+	}
+
+	if o.diff && !bytes.Equal(src, res) {
+		fmt.Fprint(o.out, unifiedDiff(name, string(src), string(res)))
+	}
+
+	if !o.list && !o.diff {
+		_, err = o.out.Write(res)
+	}
+
+	return err
+}
+
+func format(name string, in io.Reader) ([]byte, []byte, error) {
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := formatter.Format(string(src))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	return src, []byte(res), nil
+}
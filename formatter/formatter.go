@@ -0,0 +1,189 @@
+// Package formatter formats the source code, comments and the way expressions are written are kept.
+package formatter
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	ex "github.com/batrSens/LispXS/expressions"
+	"github.com/batrSens/LispXS/parser"
+)
+
+// Width is the width of lines, longer lists are broken into lines.
+const Width = 80
+
+// Format returns the formatted source. Lists are written on one line if they fit into Width and have no
+// comments, otherwise they are broken as expressions.Pretty does. Single blank lines between elements are kept.
+func Format(src string) (string, error) {
+	nodes, err := parser.NewSourceParser(src).Parse()
+	if err != nil {
+		return "", err
+	}
+
+	f := &formatter{}
+	f.lines(nodes, 0, 0)
+	if len(nodes) > 0 {
+		f.res.WriteByte('\n')
+	}
+
+	return f.res.String(), nil
+}
+
+type formatter struct {
+	res strings.Builder
+}
+
+// flat returns the node written on one line, false is returned if it has comments.
+func flat(node *parser.Node) (string, bool) {
+	if node.Comment != "" || node.Kind == parser.NodeComment {
+		return "", false
+	}
+
+	switch node.Kind {
+	case parser.NodeQuote, parser.NodeComma:
+		item, ok := flat(node.Items[0])
+		return node.Text + item, ok
+	case parser.NodeList:
+		items := make([]string, len(node.Items))
+		for i, item := range node.Items {
+			str, ok := flat(item)
+			if !ok {
+				return "", false
+			}
+			items[i] = str
+		}
+
+		return "(" + strings.Join(items, " ") + ")", true
+	default:
+		return node.Text, true
+	}
+}
+
+// print writes the node that starts at column col and returns column of its end. The comment of the node
+// isn't written.
+func (f *formatter) print(node *parser.Node, col int) int {
+	if str, ok := flat(&parser.Node{Kind: node.Kind, Text: node.Text, Items: node.Items}); ok &&
+		col+utf8.RuneCountInString(str) <= Width {
+		return f.write(str, col)
+	}
+
+	switch node.Kind {
+	case parser.NodeQuote, parser.NodeComma:
+		return f.print(node.Items[0], f.write(node.Text, col))
+	case parser.NodeList:
+		return f.list(node.Items, col)
+	default:
+		return f.write(node.Text, col)
+	}
+}
+
+func (f *formatter) list(items []*parser.Node, col int) int {
+	start := col
+	col = f.write("(", col)
+	if len(items) == 0 {
+		return f.write(")", col)
+	}
+
+	head := items[0]
+	if head.Kind != parser.NodeSymbol {
+		// data list, all items are aligned
+		return f.close(items, start+1, f.lines(items, start+1, col))
+	}
+
+	n, ok := ex.BodyArgs(head.Text)
+	if ok && head.Text == "let" && len(items) > 1 && items[1].Kind == parser.NodeSymbol {
+		// named let
+		n++
+	}
+
+	if !ok {
+		// call, arguments are aligned with the first one if the name isn't too long
+		col = f.comment(head, f.write(head.Text, col))
+		if len(items) == 1 {
+			return f.close(items, start+2, col)
+		}
+
+		indent := col + 1
+		if indent > start+Width/3 || head.Comment != "" || items[1].Kind == parser.NodeComment {
+			indent = start + 2
+			col = f.newline(indent)
+		} else {
+			col = f.write(" ", col)
+		}
+
+		return f.close(items, indent, f.lines(items[1:], indent, col))
+	}
+
+	// the first arguments of the special form are written on its line until a comment is met
+	i := 0
+	for ; i <= n && i < len(items); i++ {
+		if i > 0 {
+			if items[i-1].Comment != "" || items[i].Kind == parser.NodeComment {
+				break
+			}
+			col = f.write(" ", col)
+		}
+		col = f.print(items[i], col)
+	}
+	col = f.comment(items[i-1], col)
+
+	if i < len(items) {
+		col = f.lines(items[i:], start+2, f.separate(items[i-1], items[i], start+2))
+	}
+
+	return f.close(items, start+2, col)
+}
+
+// lines writes nodes one per line aligned at column indent, the first node starts at column col.
+func (f *formatter) lines(nodes []*parser.Node, indent, col int) int {
+	for i, node := range nodes {
+		if i > 0 {
+			col = f.separate(nodes[i-1], node, indent)
+		}
+
+		if node.Kind == parser.NodeComment {
+			col = f.write(node.Text, col)
+			continue
+		}
+
+		col = f.comment(node, f.print(node, col))
+	}
+
+	return col
+}
+
+// separate starts the new line for the node, a blank line of the source between nodes is kept.
+func (f *formatter) separate(prev, node *parser.Node, indent int) int {
+	if node.Line > prev.EndLine+1 {
+		f.res.WriteByte('\n')
+	}
+
+	return f.newline(indent)
+}
+
+// close writes the closing parenthesis, it is moved to the next line if the list ends with a comment.
+func (f *formatter) close(items []*parser.Node, indent, col int) int {
+	if last := items[len(items)-1]; last.Comment != "" || last.Kind == parser.NodeComment {
+		col = f.newline(indent)
+	}
+
+	return f.write(")", col)
+}
+
+func (f *formatter) comment(node *parser.Node, col int) int {
+	if node.Comment == "" {
+		return col
+	}
+
+	return f.write(" "+node.Comment, col)
+}
+
+func (f *formatter) newline(indent int) int {
+	f.res.WriteByte('\n')
+	return f.write(strings.Repeat(" ", indent), 0)
+}
+
+func (f *formatter) write(str string, col int) int {
+	f.res.WriteString(str)
+	return col + utf8.RuneCountInString(str)
+}
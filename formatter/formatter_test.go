@@ -0,0 +1,49 @@
+package formatter
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func TestFormat(t *testing.T) {
+	cases := []struct{ src, want string }{
+		{ // spacing is normalized and quoting style is kept
+			"(define   xs '(1 2   3))\n(write (quote x))",
+			"(define xs '(1 2 3))\n(write (quote x))\n",
+		},
+		{ // comments are kept, single blank lines between forms are kept
+			"; header\n(define a 1)   ; one\n\n\n\n(define b \"two  words\")",
+			"; header\n(define a 1) ; one\n\n(define b \"two  words\")\n",
+		},
+		{ // special forms are indented, arguments of calls are aligned
+			"(define fact (lambda (n acc) ; trailing\n (if (= n 0)\n acc ; base\n (fact (- n 1) (* n acc)))))",
+			"(define fact\n  (lambda (n acc) ; trailing\n    (if (= n 0)\n        acc ; base\n        (fact (- n 1) (* n acc)))))\n",
+		},
+		{ // comments in bodies and at the end of lists
+			"(let loop ((i 0))\n;; body\n(loop (+ i 1)))\n(foo ; head\n 1 2)\n(a b ; end\n)",
+			"(let loop ((i 0))\n  ;; body\n  (loop (+ i 1)))\n(foo ; head\n  1\n  2)\n(a b ; end\n   )\n",
+		},
+		{ // long lists are broken
+			"(define long (lambda (x) (cons 'some-very-long-symbol-name (cons 'another-long-symbol-name (cons x nil)))))",
+			"(define long\n  (lambda (x)\n    (cons 'some-very-long-symbol-name\n          (cons 'another-long-symbol-name (cons x nil)))))\n",
+		},
+		{ // empty source
+			"  \n", "",
+		},
+	}
+
+	for test, c := range cases {
+		res, err := Format(c.src)
+		assert.Equal(t, err, nil, "test#"+strconv.Itoa(test))
+		assert.Equal(t, res, c.want, "test#"+strconv.Itoa(test))
+
+		again, err := Format(res)
+		assert.Equal(t, err, nil, "test#"+strconv.Itoa(test))
+		assert.Equal(t, again, res, "test#"+strconv.Itoa(test))
+	}
+
+	_, err := Format("(a (b)")
+	assert.Equal(t, err != nil, true)
+}
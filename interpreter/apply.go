@@ -0,0 +1,254 @@
+package interpreter
+
+import (
+	ex "github.com/batrSens/LispXS/expressions"
+)
+
+// call calculates f with already calculated args. It is used by functions that call closures and functions
+// from Go code: the call is calculated by a nested run of the interpreter with its own stacks, and its result
+// (or Fatal) is returned as a result of usual function.
+func (ir *interpreter) call(f *ex.Expr, args []*ex.Expr) *ex.Expr {
+	list := ex.NewNil()
+	for i := len(args) - 1; i >= 0; i-- {
+		list = ex.NewFunction("quote").Cons(args[i].ToList()).Cons(list)
+	}
+
+	return ir.eval(f.Cons(list).ToList(), ir.varsEnvironment)
+}
+
+// eval calculates program in the vars by a nested run of the interpreter.
+func (ir *interpreter) eval(program *ex.Expr, vars *ex.Vars) *ex.Expr {
+	callStack, dataStack, control := ir.callStack, ir.dataStack, ir.control
+	argsNum, mod, varsEnvironment := ir.argsNum, ir.mod, ir.varsEnvironment
+
+	ir.callStack, ir.dataStack, ir.control = nil, nil, program
+	ir.argsNum, ir.mod, ir.varsEnvironment = 0, nil, vars
+	ir.depth++
+
+	res := ir.run()
+
+	ir.depth--
+	ir.callStack, ir.dataStack, ir.control = callStack, dataStack, control
+	ir.argsNum, ir.mod, ir.varsEnvironment = argsNum, mod, varsEnvironment
+
+	return res
+}
+
+func isCallable(f *ex.Expr) bool {
+	return f.Type == ex.Function || f.Type == ex.Closure
+}
+
+// procedureFunc returns function of one argument that must be a function or closure.
+func procedureFunc(name string, f func(proc *ex.Expr) *ex.Expr) func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+	return func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+		if len(args) != 1 {
+			return ex.NewFatal(name + ": must be 1 argument")
+		}
+
+		if !isCallable(args[0]) {
+			return ex.NewFatal(name + ": argument must be a function or closure, given " + args[0].ToString())
+		}
+
+		return f(args[0])
+	}
+}
+
+// listsArgs checks arguments '(f list...)' of map-like functions and returns lists transposed:
+// i-th element of result contains i-th elements of all lists, the length is the shortest list's one.
+func listsArgs(name string, args []*ex.Expr) ([][]*ex.Expr, *ex.Expr) {
+	if len(args) < 2 {
+		return nil, ex.NewFatal(name + ": must be at least 2 arguments")
+	}
+
+	if !isCallable(args[0]) {
+		return nil, ex.NewFatal(name + ": first argument must be a function or closure, given " + args[0].ToString())
+	}
+
+	var lists [][]*ex.Expr
+	length := -1
+	for _, arg := range args[1:] {
+		list, ok := listToSlice(arg)
+		if !ok {
+			return nil, ex.NewFatal(name + ": expected lists, given " + arg.ToString())
+		}
+
+		if length < 0 || len(list) < length {
+			length = len(list)
+		}
+
+		lists = append(lists, list)
+	}
+
+	res := make([][]*ex.Expr, length)
+	for i := range res {
+		for _, list := range lists {
+			res[i] = append(res[i], list[i])
+		}
+	}
+
+	return res, nil
+}
+
+func init() {
+	functions["map"] = Func{
+		Doc: "(map f list...) - list of results of f called with i-th elements of all lists.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			return mapLists(ir, "map", args, true)
+		},
+	}
+
+	functions["for-each"] = Func{
+		Doc: "(for-each f list...) - calls f with i-th elements of all lists, returns nil.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			return mapLists(ir, "for-each", args, false)
+		},
+	}
+
+	functions["filter"] = Func{
+		Doc: "(filter f list) - list of elements for which result of f isn't nil.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 2 {
+				return ex.NewFatal("filter: must be 2 arguments")
+			}
+
+			if !isCallable(args[0]) {
+				return ex.NewFatal("filter: first argument must be a function or closure, given " + args[0].ToString())
+			}
+
+			list, ok := listToSlice(args[1])
+			if !ok {
+				return ex.NewFatal("filter: second argument must be a list")
+			}
+
+			var res []*ex.Expr
+			for _, e := range list {
+				ok := ir.call(args[0], []*ex.Expr{e})
+				if ok.Type == ex.Fatal {
+					return ok
+				}
+
+				if !ok.IsNil() {
+					res = append(res, e)
+				}
+			}
+
+			return sliceToList(res)
+		},
+	}
+
+	functions["fold-left"] = Func{
+		Doc: "(fold-left f init list...) - (f (f init a1 b1) a2 b2) for lists (a1 a2) and (b1 b2).",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			return fold(ir, "fold-left", args, false)
+		},
+	}
+
+	functions["fold-right"] = Func{
+		Doc: "(fold-right f init list...) - (f a1 b1 (f a2 b2 init)) for lists (a1 a2) and (b1 b2).",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			return fold(ir, "fold-right", args, true)
+		},
+	}
+
+	functions["reduce"] = Func{
+		Doc: "(reduce f init list) - init for empty list, otherwise (f (f a1 a2) a3)...",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 3 {
+				return ex.NewFatal("reduce: must be 3 arguments")
+			}
+
+			if !isCallable(args[0]) {
+				return ex.NewFatal("reduce: first argument must be a function or closure, given " + args[0].ToString())
+			}
+
+			list, ok := listToSlice(args[2])
+			if !ok {
+				return ex.NewFatal("reduce: third argument must be a list")
+			}
+
+			if len(list) == 0 {
+				return args[1]
+			}
+
+			acc := list[0]
+			for _, e := range list[1:] {
+				acc = ir.call(args[0], []*ex.Expr{acc, e})
+				if acc.Type == ex.Fatal {
+					return acc
+				}
+			}
+
+			return acc
+		},
+	}
+
+	functions["apply"] = Func{
+		Doc: "(apply f arg... list) - calls f with args and elements of list as arguments.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) < 2 {
+				return ex.NewFatal("apply: must be at least 2 arguments")
+			}
+
+			if !isCallable(args[0]) {
+				return ex.NewFatal("apply: first argument must be a function or closure, given " + args[0].ToString())
+			}
+
+			list, ok := listToSlice(args[len(args)-1])
+			if !ok {
+				return ex.NewFatal("apply: last argument must be a list")
+			}
+
+			callArgs := append(append([]*ex.Expr{}, args[1:len(args)-1]...), list...)
+			return ir.call(args[0], callArgs)
+		},
+	}
+}
+
+func mapLists(ir *interpreter, name string, args []*ex.Expr, collect bool) *ex.Expr {
+	lists, fatal := listsArgs(name, args)
+	if fatal != nil {
+		return fatal
+	}
+
+	var res []*ex.Expr
+	for _, callArgs := range lists {
+		e := ir.call(args[0], callArgs)
+		if e.Type == ex.Fatal {
+			return e
+		}
+
+		if collect {
+			res = append(res, e)
+		}
+	}
+
+	return sliceToList(res)
+}
+
+// fold calculates '(fold-left f init list...)' as (f (f init a1 b1) a2 b2) and
+// '(fold-right f init list...)' as (f a1 b1 (f a2 b2 init)).
+func fold(ir *interpreter, name string, args []*ex.Expr, right bool) *ex.Expr {
+	if len(args) < 3 {
+		return ex.NewFatal(name + ": must be at least 3 arguments")
+	}
+
+	lists, fatal := listsArgs(name, append([]*ex.Expr{args[0]}, args[2:]...))
+	if fatal != nil {
+		return fatal
+	}
+
+	acc := args[1]
+	for i := range lists {
+		if right {
+			acc = ir.call(args[0], append(append([]*ex.Expr{}, lists[len(lists)-1-i]...), acc))
+		} else {
+			acc = ir.call(args[0], append([]*ex.Expr{acc}, lists[i]...))
+		}
+
+		if acc.Type == ex.Fatal {
+			return acc
+		}
+	}
+
+	return acc
+}
@@ -0,0 +1,302 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	ex "github.com/batrSens/LispXS/expressions"
+	"github.com/batrSens/LispXS/parser"
+)
+
+// ErrQuit is returned by Command for :quit.
+var ErrQuit = errors.New("quit")
+
+// envWidth limits the width of values listed by :env.
+const envWidth = 80
+
+type command struct {
+	usage string
+	run   func(in *Interpreter, arg string, out io.Writer) (*ex.Expr, error)
+}
+
+var commands map[string]command
+
+func init() {
+	// :help lists the commands, so they can't refer to each other in the declaration
+	commands = map[string]command{
+		":load":   {"file - calculates the file in the global scope", (*Interpreter).loadCommand},
+		":reload": {"- calculates the last loaded file again", (*Interpreter).reloadCommand},
+		":env":    {"- lists variables of the current scope and its parents", (*Interpreter).envCommand},
+		":type":   {"expr - type of the result", (*Interpreter).typeCommand},
+		":time":   {"expr... - calculates and prints time and steps", (*Interpreter).timeCommand},
+		":expand": {"expr - expands macro calls", (*Interpreter).expandCommand},
+		":trace":  {"name - prints calls and results of the closure, again to stop", (*Interpreter).traceCommand},
+		":quit":   {"- exits", func(*Interpreter, string, io.Writer) (*ex.Expr, error) { return nil, ErrQuit }},
+		":help":   {"- lists commands", helpCommand},
+	}
+}
+
+// IsCommand reports whether the source is a REPL command. Other words starting with ':' are keywords.
+func IsCommand(src string) bool {
+	_, ok := commands[commandName(src)]
+	return ok
+}
+
+func commandName(src string) string {
+	fields := strings.Fields(src)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return fields[0]
+}
+
+// Command runs the REPL command and writes its output to out. It returns the result of calculated code
+// (nil if nothing is calculated), so exit and interruption can be handled by the caller.
+func (in *Interpreter) Command(src string, out io.Writer) (*ex.Expr, error) {
+	name := commandName(src)
+	cmd, ok := commands[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown command %s", name)
+	}
+
+	arg := strings.TrimSpace(strings.TrimSpace(src)[len(name):])
+	return cmd.run(in, arg, out)
+}
+
+func helpCommand(_ *Interpreter, _ string, out io.Writer) (*ex.Expr, error) {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintln(out, name, commands[name].usage)
+	}
+
+	return nil, nil
+}
+
+func (in *Interpreter) loadCommand(arg string, out io.Writer) (*ex.Expr, error) {
+	if arg == "" {
+		return nil, errors.New(":load: expected path")
+	}
+
+	return in.printLoaded(in.Load(strings.Trim(arg, `"`)), out)
+}
+
+func (in *Interpreter) reloadCommand(arg string, out io.Writer) (*ex.Expr, error) {
+	if in.loaded == "" {
+		return nil, errors.New(":reload: no file is loaded")
+	}
+
+	return in.printLoaded(in.Reload(), out)
+}
+
+func (in *Interpreter) printLoaded(res *ex.Expr, out io.Writer) (*ex.Expr, error) {
+	if res.Type != ex.Fatal {
+		fmt.Fprintln(out, "loaded", in.loaded)
+	}
+
+	return res, nil
+}
+
+func (in *Interpreter) envCommand(_ string, out io.Writer) (*ex.Expr, error) {
+	for i, frame := range in.Env() {
+		if i > 0 {
+			fmt.Fprintln(out, "--")
+		}
+
+		var names []string
+		for name := range frame {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			line := []rune(name + " = " + frame[name].ReadableString())
+			if len(line) > envWidth {
+				line = append(line[:envWidth-3], []rune("...")...)
+			}
+
+			fmt.Fprintln(out, string(line))
+		}
+	}
+
+	return nil, nil
+}
+
+func (in *Interpreter) typeCommand(arg string, out io.Writer) (*ex.Expr, error) {
+	form, err := parseForm(":type", arg)
+	if err != nil {
+		return nil, err
+	}
+
+	res := in.Eval(form.ToList())
+	if res.Type != ex.Fatal {
+		fmt.Fprintln(out, res.TypeName())
+	}
+
+	return res, nil
+}
+
+func (in *Interpreter) timeCommand(arg string, out io.Writer) (*ex.Expr, error) {
+	program, err := parser.NewParser(arg).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	res, elapsed, steps := in.Time(program)
+	if res.Type != ex.Fatal {
+		fmt.Fprintln(out, ex.Pretty(res, prettyWidth))
+	}
+	fmt.Fprintf(out, "time: %v, steps: %d\n", elapsed, steps)
+
+	return res, nil
+}
+
+func (in *Interpreter) expandCommand(arg string, out io.Writer) (*ex.Expr, error) {
+	form, err := parseForm(":expand", arg)
+	if err != nil {
+		return nil, err
+	}
+
+	res := in.Expand(form)
+	if res.Type != ex.Fatal {
+		fmt.Fprintln(out, ex.Pretty(res, prettyWidth))
+	}
+
+	return res, nil
+}
+
+func (in *Interpreter) traceCommand(arg string, out io.Writer) (*ex.Expr, error) {
+	traced, err := in.Trace(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	if traced {
+		fmt.Fprintln(out, "tracing", arg)
+	} else {
+		fmt.Fprintln(out, "stopped tracing", arg)
+	}
+
+	return nil, nil
+}
+
+func parseForm(name, src string) (*ex.Expr, error) {
+	program, err := parser.NewParser(src).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	if program.Type != ex.Pair || !program.Cdr().IsNil() {
+		return nil, errors.New(name + ": expected one expression")
+	}
+
+	return program.Car(), nil
+}
+
+// Load calculates expressions of the file in the global scope as import does and remembers the file for Reload.
+func (in *Interpreter) Load(path string) *ex.Expr {
+	if abs, err := resolvePath("", path); err == nil {
+		path = abs
+		in.loaded = abs
+	}
+
+	return in.Eval(sliceToList([]*ex.Expr{ex.NewFunction("import"), quoted(ex.NewSymbol(path))}).ToList())
+}
+
+// Reload calculates the last loaded file again, files required by it are calculated again too.
+func (in *Interpreter) Reload() *ex.Expr {
+	in.interpreter.modules = map[string][]binding{}
+	return in.Load(in.loaded)
+}
+
+// Env returns variables of the current scope and its parents, builtin functions are omitted.
+func (in *Interpreter) Env() []map[string]*ex.Expr {
+	var res []map[string]*ex.Expr
+	for vars := in.interpreter.varsEnvironment; vars != nil; vars = vars.Parent {
+		frame := map[string]*ex.Expr{}
+		for name, value := range vars.CurSymbols {
+			if value.Type == ex.Function && value.String == name {
+				continue
+			}
+
+			frame[name] = value
+		}
+
+		res = append(res, frame)
+	}
+
+	return res
+}
+
+// Time calculates the program and returns its result, wall time and the number of calculated elements.
+func (in *Interpreter) Time(program *ex.Expr) (*ex.Expr, time.Duration, int64) {
+	steps, start := in.interpreter.steps, time.Now()
+	res := in.Eval(program)
+
+	return res, time.Since(start), in.interpreter.steps - steps
+}
+
+// Expand expands the form while it is a macro call, then macro calls nested in it.
+func (in *Interpreter) Expand(form *ex.Expr) *ex.Expr {
+	res := in.Eval(sliceToList([]*ex.Expr{ex.NewFunction("macroexpand"), quoted(form)}).ToList())
+	if res.Type == ex.Fatal {
+		return res
+	}
+
+	return in.interpreter.expandForm(res)
+}
+
+// Trace switches tracing of the closure bound to the name, traced closures print their calls and results
+// to the output. It returns true if the closure is traced now.
+func (in *Interpreter) Trace(name string) (bool, error) {
+	ir := in.interpreter
+	closure, ok := ir.varsEnvironment.Lookup(name)
+	if !ok {
+		return false, errors.New("trace: symbol '" + name + "' is not defined")
+	}
+
+	if closure.Type != ex.Closure {
+		return false, errors.New("trace: " + name + " is not a closure")
+	}
+
+	if _, ok := ir.traced[closure]; ok {
+		delete(ir.traced, closure)
+		return false, nil
+	}
+
+	ir.traced[closure] = name
+	return true, nil
+}
+
+func quoted(e *ex.Expr) *ex.Expr {
+	return ex.NewFunction("quote").Cons(e.ToList())
+}
+
+func (ir *interpreter) traceCall(closure *ex.Expr, args []*ex.Expr) {
+	name, ok := ir.traced[closure]
+	if !ok {
+		return
+	}
+
+	call := sliceToList(append([]*ex.Expr{ex.NewSymbol(name)}, args...))
+	fmt.Fprintf(ir.stdout, "%s> %s\n", strings.Repeat("  ", ir.traceDepth), call.ReadableString())
+	ir.traceDepth++
+}
+
+func (ir *interpreter) traceReturn(closure *ex.Expr) {
+	if _, ok := ir.traced[closure]; !ok {
+		return
+	}
+
+	ir.traceDepth--
+	fmt.Fprintf(ir.stdout, "%s< %s\n", strings.Repeat("  ", ir.traceDepth), ir.dataStack.Last().ReadableString())
+}
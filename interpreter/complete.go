@@ -0,0 +1,131 @@
+package interpreter
+
+import (
+	"sort"
+	"strings"
+
+	ex "github.com/batrSens/LispXS/expressions"
+)
+
+// undefinedSymbol is the last error of undefined symbol and the scope where the symbol is looked up.
+type undefinedSymbol struct {
+	fatal *ex.Expr
+	name  string
+	vars  *ex.Vars
+}
+
+// visibleNames returns sorted names of variables of the scope and its parents and of builtin functions.
+func visibleNames(scope *ex.Vars) []string {
+	set := map[string]struct{}{}
+	for name := range functions {
+		set[name] = struct{}{}
+	}
+
+	for vars := scope; vars != nil; vars = vars.Parent {
+		for name := range vars.CurSymbols {
+			set[name] = struct{}{}
+		}
+	}
+
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Complete returns sorted names of visible variables and builtin functions starting with the prefix.
+func (in *Interpreter) Complete(prefix string) []string {
+	var res []string
+	for _, name := range visibleNames(in.interpreter.varsEnvironment) {
+		if strings.HasPrefix(name, prefix) {
+			res = append(res, name)
+		}
+	}
+
+	return res
+}
+
+// undefined returns the error of the undefined symbol. Errors are often caught, so the closest visible name is
+// searched by suggest only when the error is reported.
+func (ir *interpreter) undefined(name string) *ex.Expr {
+	fatal := ex.NewFatal("call: symbol '" + name + "' is not defined")
+	ir.undefinedSymbol = undefinedSymbol{fatal: fatal, name: name, vars: ir.varsEnvironment}
+
+	return fatal
+}
+
+// suggest adds the closest visible name to the reported error of undefined symbol if there is such name.
+func (ir *interpreter) suggest(fatal *ex.Expr) {
+	undefined := ir.undefinedSymbol
+	if undefined.fatal != fatal {
+		return
+	}
+	ir.undefinedSymbol = undefinedSymbol{}
+
+	if similar := similarName(undefined.name, undefined.vars); similar != "" {
+		fatal.String += ", did you mean '" + similar + "'?"
+	}
+}
+
+// similarName returns the name visible in the scope closest to the name if it differs in at most a third of
+// the letters.
+func similarName(name string, scope *ex.Vars) string {
+	limit := len(name) / 3
+	if limit < 1 {
+		limit = 1
+	}
+	if limit >= len(name) {
+		return ""
+	}
+
+	res, best := "", limit+1
+	for _, candidate := range visibleNames(scope) {
+		if d := editDistance(name, candidate); d < best {
+			res, best = candidate, d
+		}
+	}
+
+	return res
+}
+
+// editDistance counts inserted, deleted and replaced letters and swaps of adjacent letters needed to get b from a.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	rows := make([][]int, len(ra)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(rb)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			d := minInt(rows[i-1][j]+1, minInt(rows[i][j-1]+1, rows[i-1][j-1]+cost))
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d = minInt(d, rows[i-2][j-2]+1)
+			}
+
+			rows[i][j] = d
+		}
+	}
+
+	return rows[len(ra)][len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
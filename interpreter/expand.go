@@ -0,0 +1,349 @@
+package interpreter
+
+import (
+	ex "github.com/batrSens/LispXS/expressions"
+)
+
+// expander expands macro calls of code before its calculation. Only macros whose definitions are known and
+// whose arguments aren't calculated are expanded, other macro calls are left to be expanded at run time. Calls of
+// a macro met in its own expansion are left to run time too, so recursion of macros which stops by a run time
+// condition isn't unrolled.
+type expander struct {
+	ir   *interpreter
+	vars *ex.Vars
+
+	// active are macros being expanded
+	active map[*ex.Expr]struct{}
+}
+
+// expandClosure expands the body of the closure once, the result is cached in the closure.
+func (ir *interpreter) expandClosure(closure *ex.Expr) {
+	shadowed := map[string]struct{}{}
+	for _, name := range closure.Params() {
+		shadowed[name] = struct{}{}
+	}
+
+	e := &expander{ir: ir, vars: closure.ParentVars, active: map[*ex.Expr]struct{}{}}
+
+	body, _ := listToSlice(closure.ClosureBody().Cdr())
+	closure.SetClosureBody(e.expandBody(body, shadowed))
+}
+
+// expandForm expands the form calculated at the top level of the program.
+func (ir *interpreter) expandForm(form *ex.Expr) *ex.Expr {
+	e := &expander{ir: ir, vars: ir.varsEnvironment, active: map[*ex.Expr]struct{}{}}
+	return e.expand(form, map[string]struct{}{})
+}
+
+// macro returns the macro called by the form's head.
+func (e *expander) macro(head *ex.Expr, shadowed map[string]struct{}) *ex.Expr {
+	if head.Type != ex.Symbol {
+		return nil
+	}
+
+	if _, ok := shadowed[head.String]; ok {
+		return nil
+	}
+
+	macro, ok := e.vars.Lookup(head.String)
+	if !ok || macro.Type != ex.Macro {
+		return nil
+	}
+
+	return macro
+}
+
+func (e *expander) expand(form *ex.Expr, shadowed map[string]struct{}) *ex.Expr {
+	if form.Type != ex.Pair || !isList(form) {
+		return form
+	}
+
+	head := form.Car()
+	if isQuote(head) {
+		return form
+	}
+
+	items, _ := listToSlice(form)
+	if macro := e.macro(head, shadowed); macro != nil {
+		return e.expandMacroCall(form, macro, items, shadowed)
+	}
+
+	if e.specialForm(head, shadowed) {
+		switch head.String {
+		case "defmacro", "define-syntax", "syntax-rules":
+			return form
+
+		case "lambda":
+			// the closure expands its body before the first call and keeps the source for procedure-source
+			return form
+
+		case "let", "let*", "letrec":
+			return e.expandLet(items, shadowed)
+
+		case "do":
+			return e.expandDo(items, shadowed)
+
+		case "for":
+			return e.expandFor(items, shadowed)
+
+		case "cond":
+			// clauses aren't calls, their tests and bodies are expanded
+			return e.expandClauses(items, 1, 0, shadowed)
+
+		case "case":
+			// datums of clauses aren't calculated
+			return e.expandClauses(items, 2, 1, shadowed)
+
+		case "catch":
+			// tags of clauses aren't calculated
+			return e.expandClauses(items, 2, 1, shadowed)
+
+		case "module":
+			if len(items) > 2 {
+				return items[0].Cons(items[1].Cons(items[2].Cons(e.expandItems(items[3:], shadowed))))
+			}
+		}
+	}
+
+	return e.expandItems(items, shadowed)
+}
+
+// expandMacroCall expands the call of the macro whose arguments aren't calculated. If arguments of the macro
+// are calculated, only they are expanded.
+func (e *expander) expandMacroCall(form, macro *ex.Expr, items []*ex.Expr, shadowed map[string]struct{}) *ex.Expr {
+	exec := macro.MacroExecMod()
+	if macro.Rules == nil && (exec == nil || len(exec) > 0) {
+		res := make([]*ex.Expr, len(items))
+		copy(res, items)
+		for i := 1; i < len(items); i++ {
+			if _, ok := exec[i]; ok || exec == nil {
+				res[i] = e.expand(items[i], shadowed)
+			}
+		}
+
+		return sliceToList(res)
+	}
+
+	if _, ok := e.active[macro]; ok {
+		return form
+	}
+
+	res, _ := e.ir.expandMacro(form, e.vars)
+	if res.Type == ex.Fatal {
+		// the error is reported when the call is calculated
+		return form
+	}
+
+	e.active[macro] = struct{}{}
+	res = e.expand(res, shadowed)
+	delete(e.active, macro)
+
+	return res
+}
+
+// specialForm reports whether the head is a builtin special form which isn't rebound by the program.
+func (e *expander) specialForm(head *ex.Expr, shadowed map[string]struct{}) bool {
+	if head.Type == ex.Function {
+		return true
+	}
+
+	if head.Type != ex.Symbol {
+		return false
+	}
+
+	if _, ok := shadowed[head.String]; ok {
+		return false
+	}
+
+	value, ok := e.vars.Lookup(head.String)
+	return ok && value.Type == ex.Function && value.String == head.String
+}
+
+// expandBody expands forms of a body, names defined by the body shadow macros in all its forms.
+func (e *expander) expandBody(items []*ex.Expr, shadowed map[string]struct{}) *ex.Expr {
+	return e.expandItems(items, withShadowed(shadowed, definedNames(items)))
+}
+
+// definedNames returns names bound by define, defmacro and define-syntax forms of the body, including ones
+// nested in begin. Local macros are expanded at run time, so their names shadow macros too.
+func definedNames(items []*ex.Expr) []string {
+	var names []string
+	for _, item := range items {
+		form, ok := listToSlice(item)
+		if !ok || item.Type != ex.Pair || len(form) < 2 || form[0].Type != ex.Symbol {
+			continue
+		}
+
+		switch form[0].String {
+		case "define", "defmacro", "define-syntax":
+			if form[1].Type == ex.Symbol {
+				names = append(names, form[1].String)
+			}
+		case "begin":
+			names = append(names, definedNames(form[1:])...)
+		}
+	}
+
+	return names
+}
+
+func (e *expander) expandItems(items []*ex.Expr, shadowed map[string]struct{}) *ex.Expr {
+	res := make([]*ex.Expr, len(items))
+	for i, item := range items {
+		res[i] = e.expand(item, shadowed)
+	}
+
+	return sliceToList(res)
+}
+
+// expandClauses expands items before the clauses starting at the position and elements of clauses after skip
+// first ones.
+func (e *expander) expandClauses(items []*ex.Expr, pos, skip int, shadowed map[string]struct{}) *ex.Expr {
+	if len(items) < pos {
+		return sliceToList(items)
+	}
+
+	res := make([]*ex.Expr, len(items))
+	copy(res, items)
+	for i := 1; i < pos; i++ {
+		res[i] = e.expand(items[i], shadowed)
+	}
+
+	for i := pos; i < len(items); i++ {
+		clause, ok := listToSlice(items[i])
+		if !ok || items[i].Type != ex.Pair || len(clause) < skip {
+			continue
+		}
+
+		for j := skip; j < len(clause); j++ {
+			clause[j] = e.expand(clause[j], shadowed)
+		}
+		res[i] = sliceToList(clause)
+	}
+
+	return sliceToList(res)
+}
+
+func (e *expander) expandLet(items []*ex.Expr, shadowed map[string]struct{}) *ex.Expr {
+	pos := 1
+	var names []string
+	if len(items) > pos && items[pos].Type == ex.Symbol {
+		names = append(names, items[pos].String)
+		pos++
+	}
+
+	if len(items) <= pos {
+		return sliceToList(items)
+	}
+
+	bindings, ok := listToSlice(items[pos])
+	if !ok {
+		return sliceToList(items)
+	}
+
+	for _, b := range bindings {
+		if b.Type == ex.Pair && b.Car().Type == ex.Symbol {
+			names = append(names, b.Car().String)
+		}
+	}
+
+	inner := withShadowed(shadowed, names)
+	inits := shadowed
+	if items[0].String != "let" {
+		inits = inner
+	}
+
+	res := make([]*ex.Expr, len(bindings))
+	for i, b := range bindings {
+		res[i] = b
+		if b.Type == ex.Pair && b.Cdr().Type == ex.Pair {
+			res[i] = b.Car().Cons(e.expand(b.Cdr().Car(), inits).Cons(b.Cdr().Cdr()))
+		}
+	}
+
+	list := sliceToList(res).Cons(e.expandBody(items[pos+1:], inner))
+	for i := pos - 1; i >= 0; i-- {
+		list = items[i].Cons(list)
+	}
+
+	return list
+}
+
+// expandDo expands '(do ((var init step)...) (test result...) body...)', steps, test, results and body see
+// the variables.
+func (e *expander) expandDo(items []*ex.Expr, shadowed map[string]struct{}) *ex.Expr {
+	if len(items) < 3 {
+		return sliceToList(items)
+	}
+
+	specs, ok := listToSlice(items[1])
+	if !ok {
+		return sliceToList(items)
+	}
+
+	var names []string
+	for _, spec := range specs {
+		if spec.Type == ex.Pair && spec.Car().Type == ex.Symbol {
+			names = append(names, spec.Car().String)
+		}
+	}
+	inner := withShadowed(shadowed, names)
+
+	res := make([]*ex.Expr, len(specs))
+	for i, spec := range specs {
+		res[i] = spec
+		parts, ok := listToSlice(spec)
+		if !ok || spec.Type != ex.Pair || len(parts) < 2 {
+			continue
+		}
+
+		parts[1] = e.expand(parts[1], shadowed)
+		if len(parts) > 2 {
+			parts[2] = e.expand(parts[2], inner)
+		}
+		res[i] = sliceToList(parts)
+	}
+
+	clause := items[2]
+	if parts, ok := listToSlice(clause); ok && clause.Type == ex.Pair {
+		clause = e.expandItems(parts, inner)
+	}
+
+	return items[0].Cons(sliceToList(res).Cons(clause.Cons(e.expandBody(items[3:], inner))))
+}
+
+// expandFor expands '(for (var from to step) body...)', the body sees the variable.
+func (e *expander) expandFor(items []*ex.Expr, shadowed map[string]struct{}) *ex.Expr {
+	if len(items) < 2 {
+		return sliceToList(items)
+	}
+
+	spec, ok := listToSlice(items[1])
+	if !ok || items[1].Type != ex.Pair || spec[0].Type != ex.Symbol {
+		return sliceToList(items)
+	}
+
+	for i := 1; i < len(spec); i++ {
+		spec[i] = e.expand(spec[i], shadowed)
+	}
+
+	inner := withShadowed(shadowed, []string{spec[0].String})
+	return items[0].Cons(sliceToList(spec).Cons(e.expandBody(items[2:], inner)))
+}
+
+func withShadowed(shadowed map[string]struct{}, names []string) map[string]struct{} {
+	if len(names) == 0 {
+		return shadowed
+	}
+
+	res := make(map[string]struct{}, len(shadowed)+len(names))
+	for name := range shadowed {
+		res[name] = struct{}{}
+	}
+
+	for _, name := range names {
+		res[name] = struct{}{}
+	}
+
+	return res
+}
@@ -0,0 +1,154 @@
+package interpreter
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	ex "github.com/batrSens/LispXS/expressions"
+)
+
+// maxDirectiveNumber limits width and precision of directives, so the result can't be made huge by a typo.
+const maxDirectiveNumber = 1 << 16
+
+// directive is a parsed formatting directive ~[width][,precision][@]char.
+type directive struct {
+	char             rune
+	width, precision int
+	left             bool
+}
+
+// formatString substitutes arguments into the control string:
+//
+//	~a   - human-readable representation as by display
+//	~s   - representation that can be read back as by write
+//	~f   - number, ~,2f has 2 digits after the point
+//	~%   - line break
+//	~~   - tilde
+//
+// Width pads the result by spaces, ~a and ~s are aligned to the left and ~f to the right, '@' swaps the alignment.
+func formatString(control string, args []*ex.Expr) (string, error) {
+	var res strings.Builder
+	for len(control) > 0 {
+		i := strings.IndexByte(control, '~')
+		if i < 0 {
+			res.WriteString(control)
+			break
+		}
+
+		res.WriteString(control[:i])
+
+		d, rest, err := parseDirective(control[i+1:])
+		if err != nil {
+			return "", err
+		}
+		control = rest
+
+		var str string
+		switch d.char {
+		case '%':
+			str = "\n"
+		case '~':
+			str = "~"
+		case 'a', 's', 'f':
+			if len(args) == 0 {
+				return "", errors.New("not enough arguments for '~" + string(d.char) + "'")
+			}
+
+			str, err = formatArg(d, args[0])
+			if err != nil {
+				return "", err
+			}
+			args = args[1:]
+		default:
+			return "", errors.New("unknown directive '~" + string(d.char) + "'")
+		}
+
+		res.WriteString(pad(str, d))
+	}
+
+	if len(args) > 0 {
+		return "", errors.New("too many arguments, " + strconv.Itoa(len(args)) + " are left")
+	}
+
+	return res.String(), nil
+}
+
+func parseDirective(control string) (directive, string, error) {
+	d := directive{width: -1, precision: -1}
+
+	var err error
+	if d.width, control, err = parseNumber(control); err != nil {
+		return d, "", err
+	}
+
+	if strings.HasPrefix(control, ",") {
+		if d.precision, control, err = parseNumber(control[1:]); err != nil {
+			return d, "", err
+		}
+	}
+
+	if strings.HasPrefix(control, "@") {
+		d.left = true
+		control = control[1:]
+	}
+
+	if control == "" {
+		return d, "", errors.New("control string ends in the middle of a directive")
+	}
+
+	c, size := utf8.DecodeRuneInString(control)
+	d.char = unicode.ToLower(c)
+	return d, control[size:], nil
+}
+
+// parseNumber parses the leading digits of str, -1 is returned if there are no digits. Numbers above
+// maxDirectiveNumber are errors.
+func parseNumber(str string) (int, string, error) {
+	i := 0
+	for i < len(str) && str[i] >= '0' && str[i] <= '9' {
+		i++
+	}
+
+	if i == 0 {
+		return -1, str, nil
+	}
+
+	n, err := strconv.Atoi(str[:i])
+	if err != nil || n > maxDirectiveNumber {
+		return -1, "", errors.New("wrong number " + str[:i] + " in directive, it must be at most " + strconv.Itoa(maxDirectiveNumber))
+	}
+
+	return n, str[i:], nil
+}
+
+func formatArg(d directive, arg *ex.Expr) (string, error) {
+	switch d.char {
+	case 's':
+		return arg.ReadableString(), nil
+	case 'f':
+		if arg.Type != ex.Number {
+			return "", errors.New("'~f' expects a number, given " + arg.ToString())
+		}
+
+		return strconv.FormatFloat(arg.Number, 'f', d.precision, 64), nil
+	default:
+		return arg.ToString(), nil
+	}
+}
+
+func pad(str string, d directive) string {
+	n := d.width - utf8.RuneCountInString(str)
+	if n <= 0 {
+		return str
+	}
+
+	// numbers are aligned to the right, '@' swaps alignment
+	if (d.char == 'f') != d.left {
+		return strings.Repeat(" ", n) + str
+	}
+
+	return str + strings.Repeat(" ", n)
+}
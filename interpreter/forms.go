@@ -0,0 +1,295 @@
+package interpreter
+
+import (
+	ex "github.com/batrSens/LispXS/expressions"
+)
+
+var letNames = map[int]string{
+	ModLet:     "let",
+	ModLetStar: "let*",
+	ModLetrec:  "letrec",
+}
+
+func letFunc(name string) func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+	return func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+		bindings := 0
+		if len(args) > 0 && args[0].Type == ex.Symbol {
+			bindings = 1
+		}
+
+		if len(args) <= bindings {
+			return ex.NewFatal(name + ": must be at least 2 arguments")
+		}
+
+		if len(args) <= bindings+1+args[bindings].Length() {
+			return ex.NewFatal(name + ": nil body")
+		}
+
+		return args[len(args)-1]
+	}
+}
+
+// modLet calculates let, let* and letrec. Init expressions are inserted into the control right after
+// the bindings list, so their values are pushed onto the data stack as usual arguments. The body is
+// calculated in a new scope that is dropped together with the list like closure's one.
+func modLet(ir *interpreter) bool {
+	pos := ir.argsNum - 1
+	cur := ir.getCurSymbol()
+
+	if pos == 1 && cur.Type == ex.Symbol && ir.mod.Type == ModLet {
+		ir.mod = &Mod{Type: ModLet, Name: cur.String}
+		ir.dataStack.Push(cur)
+		return true
+	}
+
+	if ir.mod.Body == 0 {
+		return letBindings(ir, pos, cur)
+	}
+
+	n := len(ir.mod.Names)
+	if pos < ir.mod.Body {
+		if k := pos - (ir.mod.Body - n); k > 0 && ir.mod.Type != ModLet {
+			bindLet(ir, ir.mod.Names[k-1], ir.dataStack.Last())
+		}
+
+		return false
+	}
+
+	if pos > ir.mod.Body {
+		return false
+	}
+
+	if ir.mod.Type != ModLet {
+		if n > 0 {
+			bindLet(ir, ir.mod.Names[n-1], ir.dataStack.Last())
+		}
+
+		return false
+	}
+
+	parent := ir.varsEnvironment
+	if ir.mod.Name != "" {
+		parent = ex.NewVarsWithParent(parent)
+
+		params := ex.NewNil()
+		for i := n - 1; i >= 0; i-- {
+			params = ex.NewSymbol(ir.mod.Names[i]).Cons(params)
+		}
+
+		var body []*ex.Expr
+		for cur := ir.control; cur.Type == ex.Pair; cur = cur.Cdr() {
+			body = append(body, cur.Car())
+		}
+
+		closure := ex.NewClosure(params, body, parent)
+		closure.String = ir.mod.Name
+		parent.CurSymbols[ir.mod.Name] = closure
+	}
+
+	vars := ex.NewVarsWithParent(parent)
+	for i, v := range ir.dataStack.Top(n) {
+		vars.CurSymbols[ir.mod.Names[i]] = v
+	}
+
+	ir.setNewVars(vars)
+	return false
+}
+
+// bindLet binds the value of let* or letrec to the name. A name repeated in let* is bound in a nested scope, so
+// closures created by the previous inits see the previous value. The scope is dropped with the let's one.
+func bindLet(ir *interpreter, name string, value *ex.Expr) {
+	if ir.mod.Type == ModLetStar {
+		if _, ok := ir.varsEnvironment.CurSymbols[name]; ok {
+			ir.varsEnvironment = ex.NewVarsWithParent(ir.varsEnvironment)
+		}
+	}
+
+	ir.varsEnvironment.CurSymbols[name] = value
+}
+
+func letBindings(ir *interpreter, pos int, bindings *ex.Expr) bool {
+	name := letNames[ir.mod.Type]
+
+	var names []string
+	var inits []*ex.Expr
+	exists := map[string]struct{}{}
+
+	for cur := bindings; !cur.IsNil(); cur = cur.Cdr() {
+		if cur.Type != ex.Pair {
+			ir.dataStack.Push(ex.NewFatal(name + ": bindings must be a list"))
+			return true
+		}
+
+		b := cur.Car()
+		if b.Type != ex.Pair || b.Car().Type != ex.Symbol || b.Cdr().Type != ex.Pair || !b.Cdr().Cdr().IsNil() {
+			ir.dataStack.Push(ex.NewFatal(name + ": binding must be a list of symbol and expression, given " + b.ToString()))
+			return true
+		}
+
+		// let* binds names in turn, so they may repeat
+		if _, ok := exists[b.Car().String]; ok && ir.mod.Type != ModLetStar {
+			ir.dataStack.Push(ex.NewFatal(name + ": all names must be a different"))
+			return true
+		}
+
+		exists[b.Car().String] = struct{}{}
+		names = append(names, b.Car().String)
+		inits = append(inits, b.Cdr().Car())
+	}
+
+	rest := ir.control.Cdr()
+	for i := len(inits) - 1; i >= 0; i-- {
+		rest = inits[i].Cons(rest)
+	}
+
+	ir.control = bindings.Cons(rest)
+	ir.mod = &Mod{Type: ir.mod.Type, Name: ir.mod.Name, Names: names, Body: pos + len(names) + 1}
+
+	if ir.mod.Type != ModLet {
+		vars := ex.NewVarsWithParent(ir.varsEnvironment)
+		if ir.mod.Type == ModLetrec {
+			for _, n := range names {
+				vars.CurSymbols[n] = ex.NewNil()
+			}
+		}
+
+		ir.setNewVars(vars)
+	}
+
+	ir.dataStack.Push(bindings)
+	return true
+}
+
+func conditionalFunc(name string) func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+	return func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+		if len(args) == 0 {
+			return ex.NewFatal(name + ": must be at least one argument")
+		}
+
+		if len(args) == 1 {
+			return ex.NewNil()
+		}
+
+		return args[len(args)-1]
+	}
+}
+
+// modCond calculates clauses of cond in turn. The test of the clause is inserted into the control instead of
+// the clause itself and is followed by a placeholder; on the placeholder either the body of the clause replaces
+// the rest of the list or the calculation goes on to the next clause.
+func modCond(ir *interpreter) bool {
+	pos := ir.argsNum - 1
+
+	if ir.mod.Body > 0 && pos >= ir.mod.Body {
+		return false
+	}
+
+	if clause := ir.mod.Clause; clause != nil {
+		test := ir.dataStack.Last()
+		ir.mod = &Mod{Type: ModCond}
+
+		if test.IsNil() {
+			ir.dataStack.Push(test)
+			return true
+		}
+
+		if clause.Cdr().IsNil() {
+			ir.control = ir.getCurSymbol().ToList()
+			ir.dataStack.Push(test)
+			return true
+		}
+
+		return clauseBody(ir, "cond", pos, clause.Cdr(), test)
+	}
+
+	clause := ir.getCurSymbol()
+	if clause.Type != ex.Pair {
+		ir.dataStack.Push(ex.NewFatal("cond: clause must be a list, given " + clause.ToString()))
+		return true
+	}
+
+	test := clause.Car()
+	if test.Type == ex.Symbol && test.String == "else" {
+		if !ir.control.Cdr().IsNil() {
+			ir.dataStack.Push(ex.NewFatal("cond: else clause must be the last"))
+			return true
+		}
+
+		test = ex.NewT()
+	}
+
+	ir.mod = &Mod{Type: ModCond, Clause: clause}
+	ir.control = test.Cons(ex.NewNil().Cons(ir.control.Cdr()))
+	return false
+}
+
+// modCase calculates the key and then checks clauses of case in turn without calculating them. Body of the
+// first suitable clause replaces the rest of the list.
+func modCase(ir *interpreter) bool {
+	pos := ir.argsNum - 1
+
+	if pos == 1 || ir.mod.Body > 0 && pos >= ir.mod.Body {
+		return false
+	}
+
+	clause := ir.getCurSymbol()
+	if clause.Type != ex.Pair {
+		ir.dataStack.Push(ex.NewFatal("case: clause must be a list, given " + clause.ToString()))
+		return true
+	}
+
+	key := ir.getArg(1)
+	data := clause.Car()
+	suitable := false
+
+	if data.Type == ex.Symbol && data.String == "else" {
+		if !ir.control.Cdr().IsNil() {
+			ir.dataStack.Push(ex.NewFatal("case: else clause must be the last"))
+			return true
+		}
+
+		suitable = true
+	} else if data.Type == ex.Pair || data.IsNil() {
+		for cur := data; cur.Type == ex.Pair; cur = cur.Cdr() {
+			if cur.Car().Equal(key) {
+				suitable = true
+				break
+			}
+		}
+	} else {
+		ir.dataStack.Push(ex.NewFatal("case: data of clause must be a list, given " + data.ToString()))
+		return true
+	}
+
+	if !suitable {
+		ir.dataStack.Push(ex.NewNil())
+		return true
+	}
+
+	if clause.Cdr().IsNil() {
+		ir.dataStack.Push(ex.NewFatal("case: nil body of clause " + clause.ToString()))
+		return true
+	}
+
+	return clauseBody(ir, "case", pos, clause.Cdr(), key)
+}
+
+// clauseBody replaces the rest of the list with the body of the clause. Body '(=> f)' means
+// a call of f with the value of the test.
+func clauseBody(ir *interpreter, name string, pos int, body, value *ex.Expr) bool {
+	ir.mod = &Mod{Type: ir.mod.Type, Body: pos}
+
+	if arrow := body.Car(); arrow.Type == ex.Symbol && arrow.String == "=>" {
+		if body.Cdr().Type != ex.Pair || !body.Cdr().Cdr().IsNil() {
+			ir.dataStack.Push(ex.NewFatal(name + ": '=>' must be followed by one expression"))
+			return true
+		}
+
+		quoted := ex.NewFunction("quote").Cons(value.ToList())
+		ir.control = body.Cdr().Car().Cons(quoted.ToList()).ToList()
+		return false
+	}
+
+	ir.control = body
+	return false
+}
@@ -1,15 +1,12 @@
 package interpreter
 
 import (
-	"bufio"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"strconv"
 
 	ex "github.com/batrSens/LispXS/expressions"
 	"github.com/batrSens/LispXS/lexer"
-	"github.com/batrSens/LispXS/parser"
 )
 
 const (
@@ -19,12 +16,30 @@ const (
 	ModExec
 	ModTry
 	ModMacro
+	ModLet
+	ModLetStar
+	ModLetrec
+	ModCond
+	ModCase
+	ModWhen
+	ModUnless
+	ModDo
+	ModWhile
+	ModFor
+	ModDefine
 )
 
 type Mod struct {
 	Type int
 	Exec map[int]struct{}
 	Old  *Mod
+
+	// state of the list being calculated, mods with it are created for each list separately
+	Name   string
+	Names  []string
+	Body   int
+	Clause *ex.Expr
+	Loop   *loop
 }
 
 func modApply(ir *interpreter) bool {
@@ -57,6 +72,26 @@ func modApply(ir *interpreter) bool {
 			ir.dataStack.Push(ex.NewNil())
 			return true
 		}
+	case ModLet, ModLetStar, ModLetrec:
+		return modLet(ir)
+	case ModDo, ModWhile, ModFor:
+		return modLoop(ir)
+	case ModCond:
+		return modCond(ir)
+	case ModCase:
+		return modCase(ir)
+	case ModDefine:
+		// the name and the docstring followed by the value aren't calculated
+		if ir.argsNum == 2 || ir.argsNum == 3 && !ir.control.Cdr().IsNil() {
+			ir.dataStack.Push(ir.getCurSymbol())
+			return true
+		}
+	case ModWhen, ModUnless:
+		if ir.argsNum > 2 && ir.getArg(1).IsNil() == (ir.mod.Type == ModWhen) {
+			ir.control = ir.getCurSymbol().ToList()
+			ir.dataStack.Push(ex.NewNil())
+			return true
+		}
 	default:
 		panic("unexpected mod " + strconv.Itoa(ir.mod.Type))
 	}
@@ -66,11 +101,13 @@ func modApply(ir *interpreter) bool {
 type Func struct {
 	F   func(ir *interpreter, args []*ex.Expr) *ex.Expr
 	Mod *Mod
+	Doc string
 }
 
 var functions = map[string]Func{
 
 	"eval": {
+		Doc: "(eval expr) - calculates result of expr.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 1 {
 				return ex.NewFunction("begin").Cons(ex.NewFatal("quote: must be 1 argument").ToList())
@@ -81,6 +118,7 @@ var functions = map[string]Func{
 	},
 
 	"quote": {
+		Doc: "(quote expr) - returns expr without calculation, the same as 'expr.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 1 {
 				return ex.NewFatal("quote: must be 1 argument")
@@ -95,6 +133,7 @@ var functions = map[string]Func{
 	},
 
 	"catch": {
+		Doc: "(catch expr (tag body...)...) - calculates expr, if it throws an error whose tag starts with one of tags (or any error for 'default'), calculates body of the clause.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) == 0 {
 				return ex.NewFatal("catch: must be at least one argument")
@@ -112,6 +151,7 @@ var functions = map[string]Func{
 	},
 
 	"throw": {
+		Doc: "(throw tag [value]) - throws an error with tag and value.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 1 && len(args) != 2 {
 				return ex.NewFatal("throw: must be one or two arguments")
@@ -130,6 +170,7 @@ var functions = map[string]Func{
 	},
 
 	"car": {
+		Doc: "(car pair) - first element of pair.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 1 {
 				return ex.NewFatal("car: must be 1 argument")
@@ -140,6 +181,7 @@ var functions = map[string]Func{
 	},
 
 	"cdr": {
+		Doc: "(cdr pair) - second element of pair.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 1 {
 				return ex.NewFatal("cdr: must be 1 argument")
@@ -150,6 +192,7 @@ var functions = map[string]Func{
 	},
 
 	"cons": {
+		Doc: "(cons a b) - pair of a and b, b must be a pair or nil.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 2 {
 				return ex.NewFatal("cons: must be 2 arguments")
@@ -159,26 +202,287 @@ var functions = map[string]Func{
 		},
 	},
 
-	"define": {
+	"length": {
+		Doc: "(length list) - number of elements of list.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 1 {
+				return ex.NewFatal("length: must be 1 argument")
+			}
+
+			if !isList(args[0]) {
+				return ex.NewFatal("length: must be a list")
+			}
+
+			return ex.NewNumber(float64(args[0].Length()))
+		},
+	},
+
+	"append": {
+		Doc: "(append list...) - concatenation of lists.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) == 0 {
+				return ex.NewNil()
+			}
+
+			for _, arg := range args {
+				if !isList(arg) {
+					return ex.NewFatal("append: expected lists, given " + arg.ToString())
+				}
+			}
+
+			res := args[len(args)-1]
+			for i := len(args) - 2; i >= 0; i-- {
+				list, _ := listToSlice(args[i])
+				for j := len(list) - 1; j >= 0; j-- {
+					res = list[j].Cons(res)
+				}
+			}
+
+			return res
+		},
+	},
+
+	"reverse": {
+		Doc: "(reverse list) - list in reverse order.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 1 {
+				return ex.NewFatal("reverse: must be 1 argument")
+			}
+
+			if !isList(args[0]) {
+				return ex.NewFatal("reverse: must be a list")
+			}
+
+			res := ex.NewNil()
+			for cur := args[0]; !cur.IsNil(); cur = cur.Cdr() {
+				res = cur.Car().Cons(res)
+			}
+
+			return res
+		},
+	},
+
+	"list-ref": {
+		Doc: "(list-ref list i) - i-th element of list (from 0).",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 2 {
-				return ex.NewFatal("define: must be 2 arguments")
+				return ex.NewFatal("list-ref: must be 2 arguments")
+			}
+
+			if !isList(args[0]) {
+				return ex.NewFatal("list-ref: first argument must be a list")
+			}
+
+			i, fatal := indexArg("list-ref", args[1], args[0].Length()-1)
+			if fatal != nil {
+				return fatal
+			}
+
+			return args[0].Index(i)
+		},
+	},
+
+	"list-tail": {
+		Doc: "(list-tail list i) - list without first i elements.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 2 {
+				return ex.NewFatal("list-tail: must be 2 arguments")
+			}
+
+			if !isList(args[0]) {
+				return ex.NewFatal("list-tail: first argument must be a list")
+			}
+
+			i, fatal := indexArg("list-tail", args[1], args[0].Length())
+			if fatal != nil {
+				return fatal
+			}
+
+			return args[0].Tail(i)
+		},
+	},
+
+	"member": {
+		Doc: "(member x list) - tail of list that starts with the first element equal to x or nil.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 2 {
+				return ex.NewFatal("member: must be 2 arguments")
+			}
+
+			if !isList(args[1]) {
+				return ex.NewFatal("member: second argument must be a list")
+			}
+
+			for cur := args[1]; !cur.IsNil(); cur = cur.Cdr() {
+				if cur.Car().Equal(args[0]) {
+					return cur
+				}
+			}
+
+			return ex.NewNil()
+		},
+	},
+
+	"assoc": {
+		Doc: "(assoc key list) - first element of list of pairs whose first element is equal to key or nil.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 2 {
+				return ex.NewFatal("assoc: must be 2 arguments")
+			}
+
+			if !isList(args[1]) {
+				return ex.NewFatal("assoc: second argument must be a list")
+			}
+
+			for cur := args[1]; !cur.IsNil(); cur = cur.Cdr() {
+				if cur.Car().Type != ex.Pair {
+					return ex.NewFatal("assoc: elements of list must be pairs, given " + cur.Car().ToString())
+				}
+
+				if cur.Car().Car().Equal(args[0]) {
+					return cur.Car()
+				}
+			}
+
+			return ex.NewNil()
+		},
+	},
+
+	"last": {
+		Doc: "(last list) - last element of non-empty list.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 1 {
+				return ex.NewFatal("last: must be 1 argument")
+			}
+
+			if args[0].Type != ex.Pair {
+				return ex.NewFatal("last: must be a non-empty list")
+			}
+
+			return args[0].Index(args[0].Length() - 1)
+		},
+	},
+
+	"take": {
+		Doc: "(take list i) - first i elements of list.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 2 {
+				return ex.NewFatal("take: must be 2 arguments")
+			}
+
+			if !isList(args[0]) {
+				return ex.NewFatal("take: first argument must be a list")
+			}
+
+			n, fatal := indexArg("take", args[1], args[0].Length())
+			if fatal != nil {
+				return fatal
+			}
+
+			list, _ := listToSlice(args[0])
+			return sliceToList(list[:n])
+		},
+	},
+
+	"drop": {
+		Doc: "(drop list i) - list without first i elements.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 2 {
+				return ex.NewFatal("drop: must be 2 arguments")
+			}
+
+			if !isList(args[0]) {
+				return ex.NewFatal("drop: first argument must be a list")
+			}
+
+			n, fatal := indexArg("drop", args[1], args[0].Length())
+			if fatal != nil {
+				return fatal
+			}
+
+			return args[0].Tail(n)
+		},
+	},
+
+	"range": {
+		Doc: "(range [start] end [step]) - list of numbers from start to end (not including) with step.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) < 1 || len(args) > 3 {
+				return ex.NewFatal(fmt.Sprintf("range: expected 1, 2 or 3 expressions, got %d", len(args)))
+			}
+
+			for _, arg := range args {
+				if arg.Type != ex.Number {
+					return ex.NewFatal("range: expected numbers")
+				}
+			}
+
+			start, end, step := 0.0, args[0].Number, 1.0
+			if len(args) > 1 {
+				start, end = args[0].Number, args[1].Number
+			}
+
+			if len(args) > 2 {
+				step = args[2].Number
+			}
+
+			if step == 0 {
+				return ex.NewFatal("range: zero step")
+			}
+
+			var res []*ex.Expr
+			for i := start; step > 0 && i < end || step < 0 && i > end; i += step {
+				res = append(res, ex.NewNumber(i))
+			}
+
+			return sliceToList(res)
+		},
+	},
+
+	"define": {
+		Doc: "(define name [doc] expr) - defines variable in current scope with result of expr.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 2 && len(args) != 3 {
+				return ex.NewFatal("define: must be 2 or 3 arguments")
 			}
 
 			if args[0].Type != ex.Symbol {
 				return ex.NewFatal("define: first argument is not a symbol")
 			}
 
-			ir.varsEnvironment.CurSymbols[args[0].String] = args[1]
-			return args[1]
+			value := args[len(args)-1]
+
+			if len(args) == 3 {
+				doc, ok := ex.StringValue(args[1])
+				if !ok {
+					return ex.NewFatal("define: docstring must be a string")
+				}
+
+				if value.Type != ex.Closure && value.Type != ex.Macro {
+					return ex.NewFatal("define: docstring can be given only for closure or macro")
+				}
+
+				// the closure may be bound to other names, they keep its documentation
+				documented := *value
+				value = &documented
+				value.Doc = doc
+			}
+
+			// closure remembers the first name it is defined with, the name is shown in traces
+			if (value.Type == ex.Closure || value.Type == ex.Macro) && value.String == "" {
+				value.String = args[0].String
+			}
+
+			ir.varsEnvironment.CurSymbols[args[0].String] = value
+			return value
 		},
 		Mod: &Mod{
-			Type: ModExec,
-			Exec: map[int]struct{}{2: {}},
+			Type: ModDefine,
 		},
 	},
 
 	"defmacro": {
+		Doc: "(defmacro name params [doc] body...) - defines macro in current scope.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) < 3 {
 				return ex.NewFatal("defmacro: must be at less 3 arguments")
@@ -189,6 +493,10 @@ var functions = map[string]Func{
 			}
 
 			macro := ex.NewMacro(args[1], args[2:], ir.varsEnvironment)
+			if macro.Type == ex.Macro {
+				macro.String = args[0].String
+			}
+
 			ir.varsEnvironment.CurSymbols[args[0].String] = macro
 
 			return macro
@@ -199,7 +507,51 @@ var functions = map[string]Func{
 		},
 	},
 
+	"define-syntax": {
+		Doc: "(define-syntax name macro) - defines macro created by syntax-rules in current scope.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 2 {
+				return ex.NewFatal("define-syntax: must be 2 arguments")
+			}
+
+			if args[0].Type != ex.Symbol {
+				return ex.NewFatal("define-syntax: first argument is not a symbol")
+			}
+
+			if args[1].Type != ex.Macro {
+				return ex.NewFatal("define-syntax: second argument is not a macro")
+			}
+
+			if args[1].String == "" {
+				args[1].String = args[0].String
+			}
+
+			ir.varsEnvironment.CurSymbols[args[0].String] = args[1]
+			return args[1]
+		},
+		Mod: &Mod{
+			Type: ModExec,
+			Exec: map[int]struct{}{2: {}},
+		},
+	},
+
+	"syntax-rules": {
+		Doc: "(syntax-rules (literal...) (pattern template)...) - returns hygienic macro that expands by patterns.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) == 0 {
+				return ex.NewFatal("syntax-rules: must be at least one argument")
+			}
+
+			return ex.NewSyntaxRules(sliceToList(args), ir.varsEnvironment)
+		},
+		Mod: &Mod{
+			Type: ModExec,
+			Exec: map[int]struct{}{},
+		},
+	},
+
 	"set!": {
+		Doc: "(set! name expr) - redefines existed variable in nearest scope.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 2 {
 				return ex.NewFatal("set!: must be 2 arguments")
@@ -215,7 +567,7 @@ var functions = map[string]Func{
 					curEnv.CurSymbols[args[0].String] = args[1]
 					return args[1]
 				}
-				curEnv = ir.varsEnvironment.Parent
+				curEnv = curEnv.Parent
 			}
 
 			return ex.NewFatal("set!: symbol '" + args[0].String + "' is not defined")
@@ -227,6 +579,7 @@ var functions = map[string]Func{
 	},
 
 	"lambda": {
+		Doc: "(lambda params [doc] body...) - returns new closure with current parent scope.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) < 2 {
 				return ex.NewFatal("lambda: must be at less 2 arguments")
@@ -240,7 +593,32 @@ var functions = map[string]Func{
 		},
 	},
 
+	"let": {
+		Doc: "(let [name] ((var expr)...) body...) - calculates body in a new scope with variables bound to results of exprs.",
+		F:   letFunc("let"),
+		Mod: &Mod{
+			Type: ModLet,
+		},
+	},
+
+	"let*": {
+		Doc: "(let* ((var expr)...) body...) - like let, but each expr sees previous variables.",
+		F:   letFunc("let*"),
+		Mod: &Mod{
+			Type: ModLetStar,
+		},
+	},
+
+	"letrec": {
+		Doc: "(letrec ((var expr)...) body...) - like let, but each expr sees all variables.",
+		F:   letFunc("letrec"),
+		Mod: &Mod{
+			Type: ModLetrec,
+		},
+	},
+
 	"begin": {
+		Doc: "(begin expr...) - result of the last expression or nil.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) == 0 {
 				return ex.NewNil()
@@ -250,6 +628,7 @@ var functions = map[string]Func{
 	},
 
 	"or": {
+		Doc: "(or expr...) - the first result that isn't nil, the rest expressions aren't calculated.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			for _, arg := range args {
 				if !arg.IsNil() {
@@ -265,6 +644,7 @@ var functions = map[string]Func{
 	},
 
 	"and": {
+		Doc: "(and expr...) - nil if any result is nil, otherwise the last result.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) == 0 {
 				return ex.NewT()
@@ -278,6 +658,7 @@ var functions = map[string]Func{
 	},
 
 	"if": {
+		Doc: "(if test then [else]) - calculates then if result of test isn't nil, otherwise else.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 2 && len(args) != 3 {
 				return ex.NewFatal(fmt.Sprintf("if: expected 2 or 3 expressions, got %d", len(args)))
@@ -298,7 +679,98 @@ var functions = map[string]Func{
 		},
 	},
 
+	"cond": {
+		Doc: "(cond (test body...)... [(else body...)]) - calculates body of the first clause whose test isn't nil.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) == 0 {
+				return ex.NewNil()
+			}
+
+			return args[len(args)-1]
+		},
+		Mod: &Mod{
+			Type: ModCond,
+		},
+	},
+
+	"case": {
+		Doc: "(case key ((datum...) body...)... [(else body...)]) - calculates body of the first clause that contains key.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) == 0 {
+				return ex.NewFatal("case: must be at least one argument")
+			}
+
+			if len(args) == 1 {
+				return ex.NewNil()
+			}
+
+			return args[len(args)-1]
+		},
+		Mod: &Mod{
+			Type: ModCase,
+		},
+	},
+
+	"when": {
+		Doc: "(when test body...) - calculates body if result of test isn't nil.",
+		F:   conditionalFunc("when"),
+		Mod: &Mod{
+			Type: ModWhen,
+		},
+	},
+
+	"unless": {
+		Doc: "(unless test body...) - calculates body if result of test is nil.",
+		F:   conditionalFunc("unless"),
+		Mod: &Mod{
+			Type: ModUnless,
+		},
+	},
+
+	"do": {
+		Doc: "(do ((var init [step])...) (test result...) body...) - loop that calculates body while test is nil.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) == 0 {
+				return ex.NewFatal("do: must be at least 2 arguments")
+			}
+
+			return args[len(args)-1]
+		},
+		Mod: &Mod{
+			Type: ModDo,
+		},
+	},
+
+	"while": {
+		Doc: "(while test body...) - loop that calculates body while test isn't nil.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) == 0 {
+				return ex.NewFatal("while: must be at least one argument")
+			}
+
+			return ex.NewNil()
+		},
+		Mod: &Mod{
+			Type: ModWhile,
+		},
+	},
+
+	"for": {
+		Doc: "(for (var start end [step]) body...) - loop over numbers from start to end (not including).",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) == 0 {
+				return ex.NewFatal("for: must be at least one argument")
+			}
+
+			return ex.NewNil()
+		},
+		Mod: &Mod{
+			Type: ModFor,
+		},
+	},
+
 	">": {
+		Doc: "(> a b) - T if a is greater than b.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 2 {
 				return ex.NewFatal(fmt.Sprintf(">: expected 2 expressions, got %d", len(args)))
@@ -319,6 +791,7 @@ var functions = map[string]Func{
 	},
 
 	"<": {
+		Doc: "(< a b) - T if a is less than b.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 2 {
 				return ex.NewFatal(fmt.Sprintf("<: expected 2 expressions, got %d", len(args)))
@@ -339,6 +812,7 @@ var functions = map[string]Func{
 	},
 
 	"=": {
+		Doc: "(= a b...) - T if arguments are equivalent.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) < 2 {
 				return ex.NewFatal(fmt.Sprintf("=: expected at less 2 expressions, got %d", len(args)))
@@ -356,6 +830,7 @@ var functions = map[string]Func{
 	},
 
 	"not": {
+		Doc: "(not x) - T if x is nil, otherwise nil.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 1 {
 				return ex.NewFatal("not: must be 1 argument")
@@ -370,6 +845,7 @@ var functions = map[string]Func{
 	},
 
 	"pair?": {
+		Doc: "(pair? x) - T if x is a pair.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 1 {
 				return ex.NewFatal("pair?: must be 1 argument")
@@ -384,6 +860,7 @@ var functions = map[string]Func{
 	},
 
 	"number?": {
+		Doc: "(number? x) - T if x is a number.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 1 {
 				return ex.NewFatal("number?: must be 1 argument")
@@ -398,6 +875,7 @@ var functions = map[string]Func{
 	},
 
 	"symbol?": {
+		Doc: "(symbol? x) - T if x is a symbol.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 1 {
 				return ex.NewFatal("symbol?: must be 1 argument")
@@ -411,7 +889,73 @@ var functions = map[string]Func{
 		},
 	},
 
+	"procedure?": {
+		Doc: "(procedure? x) - T if x is a function or closure.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 1 {
+				return ex.NewFatal("procedure?: must be 1 argument")
+			}
+
+			if isCallable(args[0]) {
+				return ex.NewT()
+			}
+
+			return ex.NewNil()
+		},
+	},
+
+	"procedure-arity": {
+		Doc: "(procedure-arity f) - list of minimal and maximal numbers of positional arguments of closure f, nil for functions.",
+		F: procedureFunc("procedure-arity", func(f *ex.Expr) *ex.Expr {
+			// arity of functions isn't known, they check their arguments themselves
+			if f.Type == ex.Function {
+				return ex.NewNil()
+			}
+
+			min, max := f.Arity()
+			if max < 0 {
+				return sliceToList([]*ex.Expr{ex.NewNumber(float64(min)), ex.NewNil()})
+			}
+
+			return sliceToList([]*ex.Expr{ex.NewNumber(float64(min)), ex.NewNumber(float64(max))})
+		}),
+	},
+
+	"procedure-name": {
+		Doc: "(procedure-name f) - name of function or closure or nil.",
+		F: procedureFunc("procedure-name", func(f *ex.Expr) *ex.Expr {
+			if f.String == "" {
+				return ex.NewNil()
+			}
+
+			return ex.NewSymbol(f.String)
+		}),
+	},
+
+	"procedure-params": {
+		Doc: "(procedure-params f) - parameters of closure as they are written in lambda.",
+		F: procedureFunc("procedure-params", func(f *ex.Expr) *ex.Expr {
+			if f.Type == ex.Function {
+				return ex.NewNil()
+			}
+
+			return f.ParamsList()
+		}),
+	},
+
+	"procedure-source": {
+		Doc: "(procedure-source f) - list of expressions of closure's body as they are written.",
+		F: procedureFunc("procedure-source", func(f *ex.Expr) *ex.Expr {
+			if f.Type == ex.Function {
+				return ex.NewNil()
+			}
+
+			return f.Body()
+		}),
+	},
+
 	"len": {
+		Doc: "(len symbol) - length of symbol's name in characters.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 1 {
 				return ex.NewFatal("len: must be 1 argument")
@@ -426,6 +970,7 @@ var functions = map[string]Func{
 	},
 
 	"symbol->number": {
+		Doc: "(symbol->number symbol) - number whose string representation is the name of symbol.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 1 {
 				return ex.NewFatal("symbol->number: must be 1 argument")
@@ -452,6 +997,7 @@ var functions = map[string]Func{
 	},
 
 	"number->symbol": {
+		Doc: "(number->symbol number) - symbol whose name is string representation of number.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 1 {
 				return ex.NewFatal("number->symbol: must be 1 argument")
@@ -466,6 +1012,7 @@ var functions = map[string]Func{
 	},
 
 	"+": {
+		Doc: "(+ x...) - sum of numbers or concatenation of symbols.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) == 0 {
 				return ex.NewNumber(0.0)
@@ -498,6 +1045,7 @@ var functions = map[string]Func{
 	},
 
 	"-": {
+		Doc: "(- x...) - difference of numbers or substring of symbol's name.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) == 0 {
 				return ex.NewNumber(0.0)
@@ -551,6 +1099,7 @@ var functions = map[string]Func{
 	},
 
 	"*": {
+		Doc: "(* x...) - product of numbers.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			res := 1.0
 
@@ -566,6 +1115,7 @@ var functions = map[string]Func{
 	},
 
 	"/": {
+		Doc: "(/ x...) - quotient of numbers.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) == 0 || args[0].Type != ex.Number {
 				return ex.NewFatal("/: expected at least one number")
@@ -589,52 +1139,90 @@ var functions = map[string]Func{
 	},
 
 	"write": {
+		Doc: "(write expr [port]) - writes representation of expr that can be read back to the port (output channel by default) and returns expr.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
-			if len(args) != 1 {
-				return ex.NewFatal("write: expected one expression")
+			return writeExpr(ir, "write", args, (*ex.Expr).ReadableString)
+		},
+	},
+
+	"display": {
+		Doc: "(display expr [port]) - writes human-readable representation of expr to the port (output channel by default) and returns expr.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			return writeExpr(ir, "display", args, (*ex.Expr).ToString)
+		},
+	},
+
+	"pretty-print": {
+		Doc: "(pretty-print expr [port]) - writes expr as write does, but breaks long lists into indented lines, and returns expr.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			return writeExpr(ir, "pretty-print", args, func(expr *ex.Expr) string {
+				return ex.Pretty(expr, prettyWidth) + "\n"
+			})
+		},
+	},
+
+	"format": {
+		Doc: "(format [destination] control arg...) - substitutes args into control string by directives ~a, ~s, ~f, ~% and ~~, writes the result to the port (or output channel if destination is T) and returns it.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			// T is the destination only if the control string follows it, so (format "T") formats "T"
+			var stream *ex.Stream
+			if len(args) > 0 && (args[0].Type == ex.Port || args[0].Type == ex.Nil) ||
+				len(args) > 1 && args[0].Type == ex.Symbol && args[0].String == "T" {
+				switch args[0].Type {
+				case ex.Port:
+					stream = args[0].Stream
+				case ex.Symbol:
+					stream = ir.outputPort.Stream
+				}
+
+				args = args[1:]
 			}
 
-			_, err := fmt.Fprint(ir.stdout, args[0].ToString())
+			if len(args) == 0 || args[0].Type != ex.Symbol {
+				return ex.NewFatal("format: expected control string")
+			}
+
+			res, err := formatString(args[0].String, args[1:])
 			if err != nil {
-				return ex.NewFatal(err.Error())
+				return ex.NewFatal("format: " + err.Error())
 			}
 
-			return args[0]
+			if stream != nil {
+				if err := stream.Write(res); err != nil {
+					return ex.NewFatal("format: " + err.Error())
+				}
+			}
+
+			return ex.NewSymbol(res)
 		},
 	},
 
 	"read": {
+		Doc: "(read [port]) - reads expressions up to the end of the line from the port (input channel by default) and returns list of them.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
-			if len(args) != 0 {
-				return ex.NewFatal("read: expected zero expressions")
+			stream, fatal := portArg("read", args, 0, ir.inputPort)
+			if fatal != nil {
+				return fatal
 			}
 
-			var expr *ex.Expr
-			var exprStr string
-			for {
-				str, err := bufio.NewReader(ir.stdin).ReadString('\n')
-				if err != nil && err != io.EOF {
-					return ex.NewFatal("read: " + err.Error())
-				}
-
-				exprStr += str
+			reader, err := ir.reader(stream)
+			if err != nil {
+				return ex.NewFatal("read: " + err.Error())
+			}
 
-				expr, err = parser.NewParser(exprStr).Parse()
-				if err != nil {
-					if pErr, ok := err.(*parser.ParseError); ok && pErr.Got == lexer.TagEOF {
-						continue
-					}
-					return ex.NewFatal("read: " + err.Error())
-				} else {
-					break
-				}
+			exprs, err := reader.ReadLine()
+			if err == io.EOF {
+				return ex.NewNil()
+			} else if err != nil {
+				return ex.NewFatal("read: " + err.Error())
 			}
 
-			return expr
+			return exprs
 		},
 	},
 
 	"load": {
+		Doc: "(load path) - reads expressions from file and returns list of them.",
 		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
 			if len(args) != 1 {
 				return ex.NewFatal("load: expected one expression")
@@ -644,19 +1232,23 @@ var functions = map[string]Func{
 				return ex.NewFatal("load: expected zero expressions")
 			}
 
-			file, err := ioutil.ReadFile(args[0].String)
-			if err != nil {
-				return ex.NewFatal("load: " + err.Error())
-			}
+			_, program := ir.readFile("load", args[0].String)
+			return program
+		},
+	},
 
-			str := string(file)
+	"current-file": {
+		Doc: "(current-file) - path of the file being loaded or nil.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 0 {
+				return ex.NewFatal("current-file: must be 0 arguments")
+			}
 
-			expr, err := parser.NewParser(str).Parse()
-			if err != nil {
-				return ex.NewFatal("load: " + err.Error())
+			if len(ir.files) == 0 {
+				return ex.NewNil()
 			}
 
-			return expr
+			return ex.NewSymbol(ir.files[len(ir.files)-1])
 		},
 	},
 }
@@ -0,0 +1,59 @@
+package interpreter
+
+import (
+	ex "github.com/batrSens/LispXS/expressions"
+)
+
+// documentation returns signature and docstring of function, closure or macro.
+func documentation(name string, expr *ex.Expr) string {
+	switch expr.Type {
+	case ex.Function:
+		if doc := functions[expr.String].Doc; doc != "" {
+			return doc
+		}
+
+		return expr.String + " - no documentation"
+
+	case ex.Closure, ex.Macro:
+		params := expr.ParamsList()
+		signature := "(" + name + " . " + params.ToString() + ")"
+		if isList(params) {
+			signature = ex.NewSymbol(name).Cons(params).ToString()
+		}
+
+		if expr.Doc == "" {
+			return signature + " - no documentation"
+		}
+
+		return signature + " - " + expr.Doc
+	}
+
+	return name + " - " + expr.ToString()
+}
+
+func init() {
+	// help looks through the functions, so it can't be defined in the functions' declaration
+	functions["help"] = Func{
+		Doc: "(help name) - documentation of function, closure or macro.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 1 {
+				return ex.NewFatal("help: must be 1 argument")
+			}
+
+			if args[0].Type != ex.Symbol {
+				return ex.NewFatal("help: argument must be a symbol, given " + args[0].ToString())
+			}
+
+			expr := ir.resolveSymbol(args[0])
+			if expr.Type == ex.Fatal {
+				return expr
+			}
+
+			return ex.NewSymbol(documentation(args[0].String, expr))
+		},
+		Mod: &Mod{
+			Type: ModExec,
+			Exec: map[int]struct{}{},
+		},
+	}
+}
@@ -2,12 +2,14 @@ package interpreter
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync/atomic"
 
 	ex "github.com/batrSens/LispXS/expressions"
 	"github.com/batrSens/LispXS/parser"
@@ -18,11 +20,57 @@ type Output struct {
 	Output         *ex.Expr
 }
 
+type jsonFatal struct {
+	Tag     string   `json:"tag"`
+	Payload string   `json:"payload"`
+	Trace   []string `json:"trace"`
+	Exit    bool     `json:"exit,omitempty"` // the error is thrown by exit, the payload is the exit code
+}
+
+// MarshalJSON writes the result in the representation of write, or null if the program ends with an error
+// which is written to fatal.
+func (o *Output) MarshalJSON() ([]byte, error) {
+	res := struct {
+		Result *string    `json:"result"`
+		Stdout string     `json:"stdout"`
+		Stderr string     `json:"stderr"`
+		Fatal  *jsonFatal `json:"fatal"`
+	}{
+		Stdout: o.Stdout,
+		Stderr: o.Stderr,
+	}
+
+	res.Result, res.Fatal = resultJSON(o.Output)
+	return json.Marshal(res)
+}
+
+// resultJSON returns the result in the representation of write or the error if the result is a Fatal.
+func resultJSON(res *ex.Expr) (*string, *jsonFatal) {
+	if res.Type == ex.Fatal {
+		return nil, &jsonFatal{
+			Tag:     res.String,
+			Payload: res.Res.ReadableString(),
+			Trace:   append([]string{}, res.Trace()...),
+			Exit:    res.IsExit(),
+		}
+	}
+
+	str := res.ReadableString()
+	return &str, nil
+}
+
 type Library struct {
 	interpreter *interpreter
 }
 
+// LoadLibrary calculates the file. Relative path is resolved against working directory and then against
+// library roots, files loaded by the library are resolved against its directory.
 func LoadLibrary(path string) (*Library, error) {
+	path, err := resolvePath("", path)
+	if err != nil {
+		return nil, err
+	}
+
 	file, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
@@ -38,15 +86,22 @@ func LoadLibrary(path string) (*Library, error) {
 	outstr, errstr := bytes.NewBufferString(""), bytes.NewBufferString("")
 
 	interpreter := newInterpreter(exprs, outstr, errstr, os.Stdin)
+	interpreter.files = []string{path}
 	res := interpreter.run()
 
 	if res.Type == ex.Fatal {
+		interpreter.closePorts()
 		return nil, errors.New(res.String)
 	}
 
 	return &Library{interpreter: interpreter}, nil
 }
 
+// Close closes ports opened by the library and its calls.
+func (lib *Library) Close() {
+	lib.interpreter.closePorts()
+}
+
 func (lib *Library) Call(symbol string, args ...interface{}) (*ex.Expr, error) {
 	argsList, err := newList(true, args)
 	if err != nil {
@@ -68,7 +123,9 @@ func Execute(program string) (*Output, error) {
 
 	outstr, errstr := bytes.NewBufferString(""), bytes.NewBufferString("")
 
-	res := newInterpreter(exprs, outstr, errstr, os.Stdin).run()
+	ir := newInterpreter(exprs, outstr, errstr, os.Stdin)
+	defer ir.closePorts()
+	res := ir.run()
 
 	return &Output{
 		Stdout: outstr.String(),
@@ -84,7 +141,9 @@ func ExecuteStdout(program string) (*ex.Expr, error) {
 		return nil, err
 	}
 
-	res := newInterpreter(exprs, os.Stdout, os.Stderr, os.Stdin).run()
+	ir := newInterpreter(exprs, os.Stdout, os.Stderr, os.Stdin)
+	defer ir.closePorts()
+	res := ir.run()
 
 	return res, nil
 }
@@ -96,7 +155,9 @@ func ExecuteTo(program string, ioout, ioerr io.Writer, ioin io.Reader) (*ex.Expr
 		return nil, err
 	}
 
-	res := newInterpreter(exprs, ioout, ioerr, ioin).run()
+	ir := newInterpreter(exprs, ioout, ioerr, ioin)
+	defer ir.closePorts()
+	res := ir.run()
 
 	return res, nil
 }
@@ -158,12 +219,8 @@ func (se *stackExpr) PreLast() *ex.Expr {
 	return (*se)[len(*se)-2]
 }
 
-func (se *stackExpr) Debug() {
-	fmt.Println("STACK =======")
-	for _, e := range *se {
-		fmt.Println(e.DebugString())
-	}
-	fmt.Println("END =========")
+func (se *stackExpr) Top(n int) []*ex.Expr {
+	return (*se)[len(*se)-n:]
 }
 
 type call struct {
@@ -172,6 +229,7 @@ type call struct {
 	argsNum         int
 	mod             *Mod
 	varsEnvironment *ex.Vars
+	closure         *ex.Expr
 }
 
 type stackCall []call
@@ -194,20 +252,18 @@ func (sc *stackCall) Last() call {
 	return (*sc)[len(*sc)-1]
 }
 
-func (sc *stackCall) Debug() {
-	fmt.Println("STACK CALL =======")
-	for _, e := range *sc {
-		fmt.Println(e.argsNum, e.control.ToString())
-	}
-	fmt.Println("END ==============")
-}
-
 func (sc *stackCall) SetVars(vars *ex.Vars) {
 	last := (*sc)[len(*sc)-1]
 	last.varsEnvironment = vars
 	(*sc)[len(*sc)-1] = last
 }
 
+func (sc *stackCall) SetClosure(closure *ex.Expr) {
+	last := (*sc)[len(*sc)-1]
+	last.closure = closure
+	(*sc)[len(*sc)-1] = last
+}
+
 func (sc *stackCall) SetMod(mod *Mod) {
 	last := (*sc)[len(*sc)-1]
 	last.mod = mod
@@ -222,9 +278,33 @@ type interpreter struct {
 	argsNum         int
 	mod             *Mod
 	varsEnvironment *ex.Vars
+	depth           int
+
+	modules map[string][]binding
+	loading []*moduleFile
+	files   []string
+
+	// commandLine is the path of the script followed by its arguments
+	commandLine []string
+
+	// undefinedSymbol is completed by the suggestion of a similar name when it is reported
+	undefinedSymbol undefinedSymbol
+
+	// stopping is set by exit and interruption, the error isn't caught then
+	stopping    bool
+	interrupted int32
+
+	// steps counts calculated elements of programs, traced closures print their calls and results
+	steps      int64
+	traced     map[*ex.Expr]string
+	traceDepth int
+
+	// ports opened by programs are closed by the owner of the interpreter, so they live between runs
+	ports                            []*ex.Stream
+	readers                          map[*ex.Stream]*parser.Reader
+	inputPort, outputPort, errorPort *ex.Expr
 
 	stdout, stderr io.Writer
-	stdin          io.Reader
 }
 
 func loadPrelude() *ex.Expr {
@@ -260,13 +340,24 @@ func newInterpreter(program *ex.Expr, stdout, stderr io.Writer, stdin io.Reader)
 	return &interpreter{
 		control:         program,
 		varsEnvironment: vars,
+		modules:         map[string][]binding{},
+		traced:          map[*ex.Expr]string{},
+		readers:         map[*ex.Stream]*parser.Reader{},
+		inputPort:       ex.NewPort(ex.NewInputStream("stdin", stdin, nil)),
+		outputPort:      ex.NewPort(ex.NewOutputStream("stdout", stdout, nil)),
+		errorPort:       ex.NewPort(ex.NewOutputStream("stderr", stderr, nil)),
 		stderr:          stderr,
 		stdout:          stdout,
-		stdin:           stdin,
 	}
 }
 
 func (ir *interpreter) run() *ex.Expr {
+	if ir.depth == 0 {
+		defer func() {
+			ir.stopping = false
+		}()
+	}
+
 	ir.control = ex.NewFunction("begin").Cons(ir.control)
 
 	for {
@@ -285,13 +376,22 @@ func (ir *interpreter) run() *ex.Expr {
 		}
 
 		if !ir.control.IsNil() {
-			curExpr := ir.getCurSymbol()
 			ir.argsNum++
 
 			if ir.mod != nil && modApply(ir) {
 				continue
 			}
 
+			// mod can replace the control, so the current symbol is taken after it
+			curExpr := ir.getCurSymbol()
+
+			if atomic.CompareAndSwapInt32(&ir.interrupted, 1, 0) {
+				ir.stopping = true
+				ir.dataStack.Push(ex.NewFatal(InterruptTag))
+				continue
+			}
+			ir.steps++
+
 			switch curExpr.Type {
 			case ex.Number, ex.Nil, ex.Fatal, ex.Function, ex.Closure, ex.Macro:
 				ir.dataStack.Push(curExpr)
@@ -299,6 +399,12 @@ func (ir *interpreter) run() *ex.Expr {
 				expr := ir.resolveSymbol(curExpr)
 				ir.dataStack.Push(expr)
 			case ex.Pair:
+				// forms of the program are expanded right before calculation, so macros defined
+				// by previous forms are known
+				if len(ir.callStack) == 0 && ir.depth == 0 {
+					ir.control = ir.expandForm(curExpr).Cons(ir.control.Cdr())
+				}
+
 				ir.pushLastCall()
 			default:
 				panic(fmt.Sprint("unexpected symbol type ", curExpr.Type))
@@ -321,7 +427,6 @@ func (ir *interpreter) run() *ex.Expr {
 
 				if len(ir.callStack) == 0 {
 					if len(ir.dataStack) != 1 {
-						ir.dataStack.Debug()
 						panic("expected 1 value on the stack")
 					}
 
@@ -331,6 +436,9 @@ func (ir *interpreter) run() *ex.Expr {
 					return ir.dataStack.Pop()
 				}
 
+				if ir.callStack.Last().closure != nil {
+					ir.traceReturn(ir.callStack.Last().closure)
+				}
 				ir.popLastCallAndCheckMacro()
 
 			case ex.Closure:
@@ -354,11 +462,17 @@ func (ir *interpreter) fatalFall() *ex.Expr {
 	for i := 0; true; i++ {
 		if i > 0 {
 			if len(ir.callStack) == 0 {
-				_, _ = fmt.Fprint(ir.stderr, fatal.StackTrace())
+				// fatal of a nested run falls further through the outer one
+				if ir.depth == 0 {
+					ir.suggest(fatal)
+					if !ir.stopping {
+						_, _ = fmt.Fprint(ir.stderr, fatal.StackTrace())
+					}
+				}
 				return fatal
 			}
 
-			if f.Equal(ex.NewFunction("catch")) && ir.argsNum == 1 {
+			if f.Equal(ex.NewFunction("catch")) && ir.argsNum == 1 && !ir.stopping {
 
 				cur := ir.control.Cdr()
 				for !cur.IsNil() {
@@ -384,6 +498,13 @@ func (ir *interpreter) fatalFall() *ex.Expr {
 
 			}
 
+			if closure := ir.callStack.Last().closure; closure != nil {
+				fatal.AddTrace(closure, 0)
+				if _, ok := ir.traced[closure]; ok {
+					ir.traceDepth--
+				}
+			}
+
 			ir.popLastCall()
 		}
 
@@ -416,16 +537,15 @@ func (ir *interpreter) modLoad() {
 }
 
 func (ir *interpreter) resolveSymbol(symbol *ex.Expr) *ex.Expr {
-	curEnv := ir.varsEnvironment
-	for curEnv != nil {
-		if expr, ok := curEnv.CurSymbols[symbol.String]; ok {
-			return expr
-		}
+	if expr, ok := ir.varsEnvironment.Lookup(symbol.String); ok {
+		return expr
+	}
 
-		curEnv = curEnv.Parent
+	if ex.IsKeyword(symbol) {
+		return symbol
 	}
 
-	return ex.NewFatal(fmt.Sprintf("call: symbol '%s' is not defined", symbol.String))
+	return ir.undefined(symbol.String)
 }
 
 func (ir *interpreter) popArgs() (f *ex.Expr, args []*ex.Expr) {
@@ -441,7 +561,6 @@ func (ir *interpreter) popArgs() (f *ex.Expr, args []*ex.Expr) {
 func (ir *interpreter) nextSymbol() {
 	cdr := ir.control.Cdr()
 	if cdr.Type == ex.Fatal {
-		ir.dataStack.Debug()
 		panic(cdr.String)
 	}
 
@@ -454,6 +573,11 @@ func (ir *interpreter) getCurSymbol() *ex.Expr {
 	return car
 }
 
+// getArg returns already calculated element of the current list: 0 is the function, 1 is the first argument etc.
+func (ir *interpreter) getArg(i int) *ex.Expr {
+	return ir.dataStack[len(ir.dataStack)-(ir.argsNum-1)+i]
+}
+
 func (ir *interpreter) execFunc(f *ex.Expr, args []*ex.Expr) {
 	fn, ok := functions[f.String]
 	if !ok {
@@ -522,7 +646,29 @@ func (ir *interpreter) callClosure(closure *ex.Expr, args []*ex.Expr) {
 		return
 	}
 
+	names, defaults := closure.Defaults()
+	for i, name := range names {
+		if _, ok := vars.CurSymbols[name]; ok {
+			continue
+		}
+
+		value := ir.eval(defaults[i].ToList(), vars)
+		if value.Type == ex.Fatal {
+			ir.dataStack.Push(value)
+			ir.popLastCall()
+			return
+		}
+
+		vars.CurSymbols[name] = value
+	}
+
+	if !closure.IsExpanded() {
+		ir.expandClosure(closure)
+	}
+
 	ir.setNewVars(vars)
+	ir.callStack.SetClosure(closure)
+	ir.traceCall(closure, args)
 	ir.control = closure.ClosureBody()
 	ir.argsNum = 0
 	ir.mod = nil
@@ -538,7 +684,12 @@ func (ir *interpreter) callMacro(macro *ex.Expr, args []*ex.Expr) {
 
 	ir.callStack.SetMod(&Mod{Type: ModMacro, Old: ir.callStack.Last().mod})
 	ir.setNewVars(vars)
-	ir.control = macro.ClosureBody()
+	if macro.Rules != nil {
+		ir.control = ex.NewFunction("quote").Cons(ir.expandSyntax(macro, sliceToList(args)).ToList())
+	} else {
+		ir.control = macro.ClosureBody()
+	}
+
 	ir.argsNum = 0
 	ir.mod = nil
 }
@@ -1,11 +1,21 @@
 package interpreter
 
 import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
 	"math"
+	"net"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	ex "github.com/batrSens/LispXS/expressions"
+	"github.com/batrSens/LispXS/parser"
 
 	"github.com/magiconair/properties/assert"
 )
@@ -491,3 +501,1420 @@ func TestInterpreter(t *testing.T) {
 	assert.Equal(t, res.Output.Equal(ex.NewNumber(10)), true, "test#"+strconv.Itoa(test))
 
 }
+
+func TestLet(t *testing.T) {
+	test := 0 // let
+	res, err := Execute("(let ((a 1) (b 2)) (+ a b))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(3)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 1 let inits are calculated in outer scope
+	res, err = Execute("(define a 10) (let ((a 1) (b a)) b)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(10)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 2 let*
+	res, err = Execute("(let* ((a 1) (b (+ a 1))) (cons a (cons b nil)))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(1).Cons(ex.NewNumber(2).ToList())), true, "test#"+strconv.Itoa(test))
+
+	test++ // 3 letrec
+	res, err = Execute(`
+		(letrec ((is_even (lambda (num) (if (= num 0) T (is_odd (- num 1)))))
+		         (is_odd (lambda (num) (if (= num 0) nil (is_even (- num 1))))))
+			(is_even 12))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewT()), true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 named let
+	res, err = Execute("(let loop ((i 0) (acc nil)) (if (< i 3) (loop (+ i 1) (cons i acc)) acc))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(2).Cons(ex.NewNumber(1).Cons(ex.NewNumber(0).ToList()))), true, "test#"+strconv.Itoa(test))
+
+	test++ // 5 define in let doesn't leak to outer scope
+	res, err = Execute("(let ((a 1)) (define b 2)) b")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 6 set! through nested scopes
+	res, err = Execute("(define x 1) (let ((a 1)) (let ((b 2)) (set! x 5))) x")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(5)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 7 scope is dropped after fatal
+	res, err = Execute("(define a 3) (catch (let ((a 1)) (/ a 0)) (default a))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(3)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 8 malformed binding
+	res, err = Execute("(let ((a)) a)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 9 nil body
+	res, err = Execute("(let ((a 1)))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 10 names of let* may repeat, names of let and letrec mayn't
+	res, err = Execute("(let* ((x 1) (x (+ x 1))) x)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(2)), true, "test#"+strconv.Itoa(test))
+	res, err = Execute("(define x 10) (let* ((x 1) (f (lambda () x)) (x 2)) (cons (f) (cons x nil)))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.ToString(), "(1 2)", "test#"+strconv.Itoa(test))
+	res, err = Execute("(define x 10) (let* ((x 1) (x 2)) x) x")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(10)), true, "test#"+strconv.Itoa(test))
+	res, err = Execute("(let ((x 1) (x 2)) x)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("let: all names must be a different")), true, "test#"+strconv.Itoa(test))
+	res, err = Execute("(letrec ((x 1) (x 2)) x)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("letrec: all names must be a different")), true, "test#"+strconv.Itoa(test))
+}
+
+func TestConditionals(t *testing.T) {
+	test := 0 // cond
+	res, err := Execute("(cond ((> 1 2) 'a) ((> 3 2) 'b 'c) (else 'd))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("c")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 1 cond else
+	res, err = Execute("(cond ((> 1 2) 'a) (else 'd))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("d")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 2 cond without suitable clause
+	res, err = Execute("(cond ((> 1 2) 'a))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNil()), true, "test#"+strconv.Itoa(test))
+
+	test++ // 3 cond clause without body
+	res, err = Execute("(cond (nil) ((+ 1 2)))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(3)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 cond =>
+	res, err = Execute("(cond ((+ 1 2) => (lambda (x) (* x 10))))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(30)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 5 cond short circuit
+	res, err = Execute("(cond ((> 1 2) (/ 1 0)) (T 5) ((/ 1 0) 6))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(5)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 6 cond malformed clause
+	res, err = Execute("(cond 5)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 7 cond else is not the last
+	res, err = Execute("(cond (else 1) (T 2))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 8 case
+	res, err = Execute("(case (+ 1 1) ((1) 'one) ((2 3) 'two-three) (else 'other))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("two-three")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 9 case without suitable clause
+	res, err = Execute("(case 'x ((a) 1) ((b) 2))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNil()), true, "test#"+strconv.Itoa(test))
+
+	test++ // 10 case else
+	res, err = Execute("(case 'x ((a) (/ 1 0)) (else 'other))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("other")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 11 case malformed clause
+	res, err = Execute("(case 'x (a 1))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 12 when
+	res, err = Execute("(when (> 2 1) 'a 'b)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("b")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 13 when short circuit
+	res, err = Execute("(when (> 1 2) (/ 1 0))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNil()), true, "test#"+strconv.Itoa(test))
+
+	test++ // 14 unless
+	res, err = Execute("(unless (> 1 2) 'a 'b)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("b")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 15 unless short circuit
+	res, err = Execute("(unless (> 2 1) (/ 1 0))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNil()), true, "test#"+strconv.Itoa(test))
+}
+
+func TestLoops(t *testing.T) {
+	test := 0 // do
+	res, err := Execute("(do ((i 0 (+ i 1)) (acc nil (cons i acc))) ((= i 3) acc))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(2).Cons(ex.NewNumber(1).Cons(ex.NewNumber(0).ToList()))), true, "test#"+strconv.Itoa(test))
+
+	test++ // 1 do with body and without results
+	res, err = Execute("(do ((i 0 (+ i 1))) ((= i 3)) (write i))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNil()), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, res.Stdout, "012", "test#"+strconv.Itoa(test))
+
+	test++ // 2 every iteration of do has its own scope
+	res, err = Execute(`
+		(define fs nil)
+		(do ((i 0 (+ i 1)))
+			((= i 3) (+ (* 10 ((car fs))) ((car (cdr (cdr fs))))))
+			(set! fs (cons (lambda () i) fs)))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(20)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 3 long do
+	res, err = Execute("(do ((i 0 (+ i 1)) (s 0 (+ s i))) ((= i 100000) s))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(4999950000)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 while
+	res, err = Execute("(define i 0) (while (< i 100000) (set! i (+ i 1))) i")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(100000)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 5 for
+	res, err = Execute("(define s 0) (for (i 0 100000) (set! s (+ s i))) s")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(4999950000)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 6 for with negative step
+	res, err = Execute("(define s nil) (for (i 10 0 -4) (set! s (cons i s))) s")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(2).Cons(ex.NewNumber(6).Cons(ex.NewNumber(10).ToList()))), true, "test#"+strconv.Itoa(test))
+
+	test++ // 7 throw out of loop
+	res, err = Execute("(catch (for (i 0 10) (if (= i 5) (throw 'stop i))) (stop))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(5)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 8 for with incorrect range
+	res, err = Execute("(for (i 0 'a) 1)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 9 do without test
+	res, err = Execute("(do () 5)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+}
+
+func BenchmarkLoopDo(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_, _ = Execute("(do ((i 0 (+ i 1)) (s 0 (+ s i))) ((= i 1000) s))")
+	}
+}
+
+func BenchmarkLoopFor(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_, _ = Execute("(define s 0) (for (i 0 1000) (set! s (+ s i))) s")
+	}
+}
+
+func BenchmarkLoopRecursion(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_, _ = Execute("(define sum (lambda (i s) (if (= i 1000) s (sum (+ i 1) (+ s i))))) (sum 0 0)")
+	}
+}
+
+func TestHigherOrder(t *testing.T) {
+	test := 0 // map
+	res, err := Execute("(map (lambda (x) (* x x)) '(1 2 3))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(1).Cons(ex.NewNumber(4).Cons(ex.NewNumber(9).ToList()))), true, "test#"+strconv.Itoa(test))
+
+	test++ // 1 map over several lists
+	res, err = Execute("(map + '(1 2 3) '(10 20))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(11).Cons(ex.NewNumber(22).ToList())), true, "test#"+strconv.Itoa(test))
+
+	test++ // 2 map over closures
+	res, err = Execute("(map (lambda (f) (f 2)) (cons (lambda (x) (+ x 1)) (cons (lambda (x) (* x 10)) nil)))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(3).Cons(ex.NewNumber(20).ToList())), true, "test#"+strconv.Itoa(test))
+
+	test++ // 3 for-each
+	res, err = Execute("(define s 0) (for-each (lambda (x) (set! s (+ s x))) '(1 2 3)) s")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(6)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 filter
+	res, err = Execute("(filter (lambda (x) (> x 1)) '(1 2 3))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(2).Cons(ex.NewNumber(3).ToList())), true, "test#"+strconv.Itoa(test))
+
+	test++ // 5 fold-left
+	res, err = Execute("(fold-left - 0 '(1 2 3))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(-6)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 6 fold-right
+	res, err = Execute("(fold-right - 0 '(1 2 3))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(2)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 7 reduce
+	res, err = Execute("(reduce + 0 '(1 2 3 4))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(10)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 8 apply
+	res, err = Execute("(apply + 1 2 '(3 4))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(10)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 9 apply with symbols and lists that aren't calculated again
+	res, err = Execute("(apply (lambda args args) '(a (b c)))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("a").Cons(ex.NewSymbol("b").Cons(ex.NewSymbol("c").ToList()).ToList())), true, "test#"+strconv.Itoa(test))
+
+	test++ // 10 fatal from closure
+	res, err = Execute("(map (lambda (x) (/ 1 x)) '(1 0))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 11 catch fatal from closure
+	res, err = Execute("(catch (map (lambda (x) (/ 1 x)) '(1 0)) (default 'caught))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("caught")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 12 not a function
+	res, err = Execute("(map 5 '(1))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+}
+
+func TestLists(t *testing.T) {
+	list := func(nums ...float64) *ex.Expr {
+		res := ex.NewNil()
+		for i := len(nums) - 1; i >= 0; i-- {
+			res = ex.NewNumber(nums[i]).Cons(res)
+		}
+		return res
+	}
+
+	test := 0 // length
+	res, err := Execute("(length '(1 2 3))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(3)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 1 append
+	res, err = Execute("(append '(1 2) '(3) nil '(4 5))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(list(1, 2, 3, 4, 5)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 2 reverse
+	res, err = Execute("(reverse '(1 2 3))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(list(3, 2, 1)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 3 list-ref
+	res, err = Execute("(list-ref '(a b c) 2)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("c")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 list-ref out of range
+	res, err = Execute("(list-ref '(a b c) 3)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 5 list-tail
+	res, err = Execute("(list-tail '(1 2 3) 1)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(list(2, 3)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 6 member
+	res, err = Execute("(member 2 '(1 2 3))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(list(2, 3)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 7 assoc
+	res, err = Execute("(assoc 'b '((a 1) (b 2)))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("b").Cons(list(2))), true, "test#"+strconv.Itoa(test))
+
+	test++ // 8 last
+	res, err = Execute("(last '(1 2 3))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(3)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 9 take and drop
+	res, err = Execute("(append (drop '(1 2 3) 2) (take '(1 2 3) 2))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(list(3, 1, 2)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 10 range
+	res, err = Execute("(append (range 3) (range 5 0 -2))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(list(0, 1, 2, 5, 3, 1)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 11 sort
+	res, err = Execute("(sort '(3 1 2) <)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(list(1, 2, 3)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 12 sort with closure
+	res, err = Execute("(sort '(1 3 2) (lambda (a b) (> a b)))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(list(3, 2, 1)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 13 incorrect type
+	res, err = Execute("(length 5)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+}
+
+func TestMacroexpand(t *testing.T) {
+	test := 0 // macroexpand-1
+	res, err := Execute("(defmacro set10 (s) (cons 'set! (cons s '(10)))) (macroexpand-1 '(set10 a))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("set!").Cons(ex.NewSymbol("a").Cons(ex.NewNumber(10).ToList()))), true, "test#"+strconv.Itoa(test))
+
+	test++ // 1 macroexpand-1 with calculated argument
+	res, err = Execute("(defmacro apply1 (f ,args) (cons f args)) (define l '(1 2)) (macroexpand-1 '(apply1 + l))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("+").Cons(ex.NewNumber(1).Cons(ex.NewNumber(2).ToList()))), true, "test#"+strconv.Itoa(test))
+
+	test++ // 2 macroexpand expands until result is not a macro call
+	res, err = Execute("(defmacro m1 (x) (cons 'm2 (cons x nil))) (defmacro m2 (x) (cons '- (cons x nil))) (macroexpand '(m1 5))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("-").Cons(ex.NewNumber(5).ToList())), true, "test#"+strconv.Itoa(test))
+
+	test++ // 3 not a macro call
+	res, err = Execute("(macroexpand '(+ 1 2))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("+").Cons(ex.NewNumber(1).Cons(ex.NewNumber(2).ToList()))), true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 fatal in macro body
+	res, err = Execute("(defmacro bad (x) (/ 1 0)) (macroexpand '(bad 1))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 5 expansion isn't calculated
+	res, err = Execute("(define a 1) (defmacro set10 (s) (cons 'set! (cons s '(10)))) (macroexpand '(set10 a)) a")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(1)), true, "test#"+strconv.Itoa(test))
+}
+
+func TestSyntaxRules(t *testing.T) {
+	test := 0 // gensym
+	res, err := Execute("(gensym 'x)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, strings.HasPrefix(res.Output.ToString(), "#:x"), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, res.Output.ReadableString(), res.Output.ToString(), "test#"+strconv.Itoa(test))
+	assert.Equal(t, res.Output.IsUninterned(), true, "test#"+strconv.Itoa(test))
+
+	test++ // 1 generated symbol isn't equal to the symbol with the same name
+	res, err = Execute("(define g (gensym 'x)) (cons (= g (+ g)) (cons (= g g) (cons (= (len g) (len (+ g))) nil)))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.ToString(), "(nil T T)", "test#"+strconv.Itoa(test))
+
+	test++ // 2 gensyms are unique
+	res, err = Execute("(= (gensym) (gensym))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.IsNil(), true, "test#"+strconv.Itoa(test))
+
+	test++ // 3 variable of macro doesn't capture variable of user
+	res, err = Execute(`
+(define-syntax swap! (syntax-rules () ((_ a b) (let ((tmp a)) (set! a b) (set! b tmp)))))
+(define tmp 1)
+(define y 2)
+(swap! tmp y)
+(cons tmp (cons y nil))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(2).Cons(ex.NewNumber(1).ToList())), true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 ellipsis
+	res, err = Execute(`
+(define-syntax my-let (syntax-rules () ((_ ((n v) ...) body ...) ((lambda (n ...) body ...) v ...))))
+(my-let ((a 1) (b 2)) (+ a b))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(3)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 5 recursive macro with several rules
+	res, err = Execute(`
+(define-syntax my-or (syntax-rules () ((_) nil) ((_ e) e) ((_ e r ...) (let ((t e)) (if t t (my-or r ...))))))
+(define t 5)
+(my-or nil t)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(5)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 6 literals
+	res, err = Execute("(define-syntax f (syntax-rules (=>) ((_ a => b) b) ((_ a) a))) (+ (f 1 => 2) (f 3))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(5)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 7 no suitable pattern
+	res, err = Execute("(define-syntax f (syntax-rules () ((_ a) a))) (f 1 2)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 8 macroexpand of syntax-rules macro
+	res, err = Execute("(define-syntax f (syntax-rules () ((_ a ...) (+ a ...)))) (macroexpand '(f 1 2))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("+").Cons(ex.NewNumber(1).Cons(ex.NewNumber(2).ToList()))), true, "test#"+strconv.Itoa(test))
+}
+
+func TestExpansionPass(t *testing.T) {
+	test := 0 // body of closure is expanded once
+	res, err := Execute("(defmacro m (x) (write 'expanded) x) (define f (lambda (a) (m a))) (f 1) (f 2) (f 3)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(3)), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, res.Stdout, "expanded", "test#"+strconv.Itoa(test))
+
+	test++ // 1 loop at the top level is expanded once
+	res, err = Execute("(defmacro m (x) (write 'expanded) x) (do ((i 0 (+ i 1))) ((> i 3) i) (m i))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(4)), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, res.Stdout, "expanded", "test#"+strconv.Itoa(test))
+
+	test++ // 2 parameter shadows macro
+	res, err = Execute("(defmacro m (x) x) (define f (lambda (m) (m 5))) (f -)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(-5)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 3 let binding shadows macro
+	res, err = Execute("(defmacro m (x) x) (define f (lambda (a) (let ((m -)) (m a)))) (f 5)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(-5)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 quoted code isn't expanded
+	res, err = Execute("(defmacro m (x) x) (define f (lambda (a) '(m a))) (f 5)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("m").Cons(ex.NewSymbol("a").ToList())), true, "test#"+strconv.Itoa(test))
+
+	test++ // 5 macro with calculated arguments is expanded at run time
+	res, err = Execute("(defmacro m (,x) (write 'expanded) x) (define f (lambda (a) (m a))) (f 1) (f 2)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(2)), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, res.Stdout, "expandedexpanded", "test#"+strconv.Itoa(test))
+
+	test++ // 6 recursion of macro stopped by run time condition
+	res, err = Execute("(defmacro inf (x) (cons 'if (cons x (cons (cons 'inf (cons x nil)) (cons 7 nil))))) (inf nil)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(7)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 7 branching recursion isn't unrolled before calculation
+	res, err = Execute(`
+(define list (lambda args args))
+(defmacro cnt (n) (list 'if (list '= n 0) 1 (list '+ (list 'cnt (list '- n 1)) (list 'cnt (list '- n 1)))))
+(cnt 3)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(8)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 8 names of do variables aren't expanded
+	res, err = Execute("(define list (lambda args args)) (defmacro m args (list 'quote 'x)) (do ((m 0 (+ m 1))) ((= m 2) m))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(2)), true, "test#"+strconv.Itoa(test))
+	res, err = Execute("(define list (lambda args args)) (defmacro m args (list 'quote 'x)) (define s 0) (for (m 0 3) (set! s (+ s m))) s")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(3)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 9 datums of case and clauses of cond and catch aren't calls
+	res, err = Execute("(defmacro m args 'x) (case '(m 1) (((m 1)) 'found) (else 'not-found))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("found")), true, "test#"+strconv.Itoa(test))
+	res, err = Execute("(defmacro m args 'x) (define f (lambda (m) (cond (m 'yes) (T 'no)))) (f 1)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("yes")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 10 arguments of macro which aren't calculated aren't expanded
+	res, err = Execute(`
+(defmacro inner args 'expanded)
+(defmacro outer (,f code) (cons 'quote (cons (f code) nil)))
+(outer car (inner 1))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("inner")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 11 local definition shadows macro in the whole body
+	res, err = Execute("(define list (lambda args args)) (defmacro m (x) (list 'quote x)) (define g (lambda () (define m (lambda (x) 5)) (m 1))) (g)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(5)), true, "test#"+strconv.Itoa(test))
+	res, err = Execute("(define list (lambda args args)) (defmacro m (x) (list 'quote x)) (let () (begin (define m (lambda (x) 6))) (m 1))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(6)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 12 rebound special form is a call
+	interp, err := New(ioutil.Discard, ioutil.Discard, strings.NewReader(""))
+	assert.Equal(t, err, nil)
+	_, err = interp.EvalString("(defmacro m args 'x) (define cond (lambda args args))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, interp.Expand(ex.NewSymbol("cond").Cons(sliceToList([]*ex.Expr{ex.NewSymbol("m"), ex.NewNumber(1)}).ToList())).ToString(), "(cond x)", "test#"+strconv.Itoa(test))
+	assert.Equal(t, interp.interpreter.expandForm(sliceToList([]*ex.Expr{ex.NewSymbol("let"), ex.NewNil(), sliceToList([]*ex.Expr{ex.NewSymbol("m")})})).ToString(), "(let nil x)", "test#"+strconv.Itoa(test))
+}
+
+func TestOptionalArgs(t *testing.T) {
+	list := func(nums ...float64) *ex.Expr {
+		res := ex.NewNil()
+		for i := len(nums) - 1; i >= 0; i-- {
+			res = ex.NewNumber(nums[i]).Cons(res)
+		}
+		return res
+	}
+
+	test := 0 // optional args
+	res, err := Execute("(define f (lambda (a #!optional (b 10) c) (cons a (cons b (cons c nil))))) (cons (f 1) (cons (f 1 2 3) nil))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(1).Cons(ex.NewNumber(10).Cons(ex.NewNil().ToList())).Cons(list(1, 2, 3).ToList())), true, "test#"+strconv.Itoa(test))
+
+	test++ // 1 default value sees previous args
+	res, err = Execute("(define f (lambda (a #!optional (b (* a 2))) b)) (f 4)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(8)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 2 keyword args
+	res, err = Execute("(define f (lambda (a #:key (b 5) (c 6)) (cons a (cons b (cons c nil))))) (f 1 :c 3)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(list(1, 5, 3)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 3 keyword is calculated to itself
+	res, err = Execute(":foo")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol(":foo")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 missing argument
+	res, err = Execute("(define f (lambda (a b) a)) (f 1)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, res.Output.String, "call: missing argument 'b', expected 2 args, got 1 args", "test#"+strconv.Itoa(test))
+
+	test++ // 5 too many arguments
+	res, err = Execute("(define f (lambda (a #!optional b) a)) (f 1 2 3)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.String, "call: too many arguments, expected from 1 to 2 args, got 3 args", "test#"+strconv.Itoa(test))
+
+	test++ // 6 unknown keyword
+	res, err = Execute("(define f (lambda (a #:key b) a)) (f 1 :c 2)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.String, "call: unknown keyword argument ':c'", "test#"+strconv.Itoa(test))
+
+	test++ // 7 fatal in default value
+	res, err = Execute("(define f (lambda (a #!optional (b (/ 1 0))) a)) (f 1)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 8 default value of required arg
+	res, err = Execute("(lambda ((a 1)) a)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 9 markers in lambda lists of syntax-rules templates
+	res, err = Execute(`(define-syntax opt (syntax-rules () ((_ x) ((lambda (a #!optional (b 2) #:key (c 3)) (+ a b c)) x))))
+		(opt 1)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(6)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 10 defined keyword
+	res, err = Execute("(define :x 5) :x")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(5)), true, "test#"+strconv.Itoa(test))
+}
+
+func TestProcedures(t *testing.T) {
+	test := 0 // procedure?
+	res, err := Execute("(cons (procedure? car) (cons (procedure? (lambda (x) x)) (cons (procedure? 'car) nil)))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewT().Cons(ex.NewT().Cons(ex.NewNil().ToList()))), true, "test#"+strconv.Itoa(test))
+
+	test++ // 1 procedure-name of closure created by define
+	res, err = Execute("(define f (lambda (a) a)) (define g f) (procedure-name g)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("f")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 2 procedure-name of anonymous closure and function
+	res, err = Execute("(cons (procedure-name (lambda x x)) (procedure-name car))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNil().Cons(ex.NewSymbol("car"))), true, "test#"+strconv.Itoa(test))
+
+	test++ // 3 procedure-params
+	res, err = Execute("(define f (lambda (a #!optional (b 2) #:key c) a)) (procedure-params f)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.ToString(), "(a #!optional (b 2) #:key c)", "test#"+strconv.Itoa(test))
+
+	test++ // 4 procedure-arity
+	res, err = Execute("(cons (procedure-arity (lambda (a #!optional b) a)) (cons (procedure-arity (lambda x x)) nil))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.ToString(), "((1 2) (0 nil))", "test#"+strconv.Itoa(test))
+
+	test++ // 5 procedure-source
+	res, err = Execute("(procedure-source (lambda (a) (+ a 1)))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.ToString(), "((+ a 1))", "test#"+strconv.Itoa(test))
+
+	test++ // 6 procedure-arity of function
+	res, err = Execute("(procedure-arity car)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.IsNil(), true, "test#"+strconv.Itoa(test))
+
+	test++ // 7 procedure-source of called closure isn't expanded
+	res, err = Execute("(defmacro inc (x) (cons '+ (cons x (cons 1 nil)))) (define f (lambda (a) (inc a))) (f 1) (procedure-source f)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.ToString(), "((inc a))", "test#"+strconv.Itoa(test))
+
+	test++ // 8 not a procedure
+	res, err = Execute("(procedure-name 1)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 9 name of closure is shown in trace
+	res, err = Execute("(define f (lambda (a) (/ a 0))) (f 1)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, strings.Contains(res.Stderr, "Closure(f (a))"), true, "test#"+strconv.Itoa(test))
+}
+
+func TestHelp(t *testing.T) {
+	test := 0 // documentation of builtin
+	res, err := Execute("(help car)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("(car pair) - first element of pair.")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 1 all builtins are documented
+	for name, f := range functions {
+		assert.Equal(t, f.Doc != "", true, "test#"+strconv.Itoa(test)+" "+name)
+	}
+
+	test++ // 2 docstring of lambda isn't a part of body
+	res, err = Execute("(define f (lambda (a #!optional b) \"Adds one to a.\" (+ a 1))) (cons (f 1) (cons (help f) nil))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(2).Cons(ex.NewSymbol("(f a #!optional b) - Adds one to a.").ToList())), true, "test#"+strconv.Itoa(test))
+
+	test++ // 3 single symbol is a body, not a docstring
+	res, err = Execute("(define a 5) (define f (lambda () a)) (cons (f) (cons (help f) nil))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(5).Cons(ex.NewSymbol("(f) - no documentation").ToList())), true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 docstring of define
+	res, err = Execute("(define g \"Identity.\" (lambda x x)) (help g)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("(g . x) - Identity.")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 5 docstring of defmacro
+	res, err = Execute("(defmacro m (x) \"Macro doc.\" x) (help m)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("(m x) - Macro doc.")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 6 docstring for a number
+	res, err = Execute("(define a \"doc\" 5)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 7 bare symbol isn't a docstring
+	res, err = Execute("((lambda () undefined-var 5))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+	res, err = Execute("(define x 5) (define f (lambda () |x|)) (cons (f) (cons (help f) nil))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(5).Cons(ex.NewSymbol("(f) - no documentation").ToList())), true, "test#"+strconv.Itoa(test))
+	res, err = Execute("((lambda () |x| 'x 5))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 8 docstring of define doesn't change other names of closure
+	res, err = Execute("(define f (lambda (x) \"Doc of f.\" x)) (define g \"Doc of g.\" f) (cons (help f) (cons (help g) nil))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.ToString(), "((f x) - Doc of f. (g x) - Doc of g.)", "test#"+strconv.Itoa(test))
+}
+
+func TestModules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lispxs")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"math.lisp": "(module math (export inc twice) (define helper (lambda (x) (+ x 1))) (define inc (lambda (x) (helper x))) " +
+			"(define twice (lambda (x) (inc (inc x))))) (write 'loaded)",
+		"a.lisp": `(require "` + filepath.Join(dir, "b.lisp") + `")`,
+		"b.lisp": `(require "` + filepath.Join(dir, "a.lisp") + `")`,
+	}
+	for name, content := range files {
+		assert.Equal(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), nil)
+	}
+
+	math := `"` + filepath.Join(dir, "math.lisp") + `"`
+
+	test := 0 // exported variables
+	res, err := Execute("(require " + math + ") (twice 1)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(3)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 1 not exported variable
+	res, err = Execute("(require " + math + ") helper")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 2 file is calculated once, prefix
+	res, err = Execute("(require " + math + ") (require " + math + " 'm:) (m:inc 1)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(2)), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, res.Stdout, "loaded", "test#"+strconv.Itoa(test))
+
+	test++ // 3 circular require
+	res, err = Execute(`(require "` + filepath.Join(dir, "a.lisp") + `")`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, strings.HasPrefix(res.Output.String, "require: circular load"), true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 module in program
+	res, err = Execute("(module m (export f) (define f 1) (define g 2)) (define g 3) (+ f g)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(4)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 5 exported variable isn't defined
+	res, err = Execute("(module m (export f) (define g 2))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 6 string is calculated to itself
+	res, err = Execute(`"some string"`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("some string")), true, "test#"+strconv.Itoa(test))
+}
+
+func TestPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lispxs")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dir)
+
+	assert.Equal(t, os.MkdirAll(filepath.Join(dir, "lib", "inner"), 0755), nil)
+	assert.Equal(t, os.MkdirAll(filepath.Join(dir, "roots"), 0755), nil)
+
+	files := map[string]string{
+		"lib/main.lisp":         `(import "inner/helper.lisp") (define main-file (current-file)) (define get-data (lambda () data))`,
+		"lib/inner/helper.lisp": `(define helper-file (current-file)) (define data (load "data.lisp"))`,
+		"lib/inner/data.lisp":   `1 2 3`,
+		"roots/rooted.lisp":     `(define rooted 5)`,
+	}
+	for name, content := range files {
+		assert.Equal(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), nil)
+	}
+
+	main := filepath.Join(dir, "lib", "main.lisp")
+
+	test := 0 // paths are resolved against the file being loaded
+	res, err := Execute(`(import "` + main + `") (cons main-file (cons helper-file data))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.ToString(), "("+main+" "+filepath.Join(dir, "lib", "inner", "helper.lisp")+" 1 2 3)", "test#"+strconv.Itoa(test))
+
+	test++ // 1 current file at the top level
+	res, err = Execute("(current-file)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.IsNil(), true, "test#"+strconv.Itoa(test))
+
+	test++ // 2 library roots
+	roots := LibraryRoots
+	LibraryRoots = []string{filepath.Join(dir, "roots")}
+	res, err = Execute(`(import "rooted.lisp") rooted`)
+	LibraryRoots = roots
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewNumber(5)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 3 file isn't found
+	res, err = Execute(`(load "no-such-file.lisp")`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 library loads files relative to itself
+	lib, err := LoadLibrary(main)
+	assert.Equal(t, err, nil)
+	data, err := lib.Call("get-data")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, data.ToString(), "(1 2 3)", "test#"+strconv.Itoa(test))
+}
+
+func TestPorts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lispxs")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "out.txt")
+
+	test := 0 // string ports
+	res, err := Execute(`(define list (lambda args args)) (define in (open-input-string "ab\ncd")) (list (peek-char in) (read-char in) (read-line in) (read-line in) (read-line in) (read-char in))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.ToString(), "(a a b cd nil nil)", "test#"+strconv.Itoa(test))
+
+	test++ // 1 write to the string port
+	res, err = Execute(`(define out (open-output-string)) (write 1 out) (write '(a b) out) (get-output-string out)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.ToString(), "1(a b)", "test#"+strconv.Itoa(test))
+	assert.Equal(t, res.Stdout, "", "test#"+strconv.Itoa(test))
+
+	test++ // 2 file round trip
+	res, err = Execute(`(define out (open-output-file "` + file + `")) (display "line 1" out) (display "\nline 2" out) (close-port out)
+(call-with-port (open-input-file "` + file + `") (lambda (in) ((lambda args args) (read-line in) (read-line in) (read-line in))))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.ToString(), "(line 1 line 2 nil)", "test#"+strconv.Itoa(test))
+
+	test++ // 3 closed port
+	res, err = Execute(`(define in (open-input-string "abc")) (close-port in) (read-char in)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("read-char: port is closed")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 call-with-port closes the port on error
+	res, err = Execute(`(define in (open-input-string "abc")) (catch (call-with-port in (lambda (p) (car 1))) (default 0)) (read-char in)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("read-char: port is closed")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 5 current ports
+	res, err = Execute(`(write 'x (current-output-port)) (write 'y (current-error-port)) (port? (current-input-port))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewT()), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, res.Stdout, "x", "test#"+strconv.Itoa(test))
+	assert.Equal(t, res.Stderr, "y", "test#"+strconv.Itoa(test))
+
+	test++ // 6 file doesn't exist
+	res, err = Execute(`(open-input-file "` + filepath.Join(dir, "none") + `")`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Type, ex.Fatal, "test#"+strconv.Itoa(test))
+
+	test++ // 7 ports of library are opened until it is closed
+	logFile, libFile := filepath.Join(dir, "log.txt"), filepath.Join(dir, "log.lisp")
+	err = ioutil.WriteFile(libFile, []byte(`(define out (open-output-file "`+logFile+`")) (define log (lambda (x) (display x out)))`), 0644)
+	assert.Equal(t, err, nil)
+	lib, err := LoadLibrary(libFile)
+	assert.Equal(t, err, nil)
+	_, err = lib.Call("log", "a")
+	assert.Equal(t, err, nil)
+	data, err := lib.Call("log", "b")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, data.Type, ex.Symbol, "test#"+strconv.Itoa(test))
+	lib.Close()
+	logged, err := ioutil.ReadFile(logFile)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(logged), "ab", "test#"+strconv.Itoa(test))
+
+	test++ // 8 closed port is forgotten
+	in, err := New(ioutil.Discard, ioutil.Discard, strings.NewReader(""))
+	assert.Equal(t, err, nil)
+	_, err = in.EvalString(`(define p (open-input-string "a")) (read p) (close-port p)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(in.interpreter.ports)+len(in.interpreter.readers), 0, "test#"+strconv.Itoa(test))
+	_, err = in.EvalString(`(call-with-port (open-input-string "a") (lambda (p) (read p)))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(in.interpreter.ports)+len(in.interpreter.readers), 0, "test#"+strconv.Itoa(test))
+	_, err = in.EvalString(`(catch (call-with-port (open-input-string "a") (lambda (p) (read p) (car 1))) (default 0))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(in.interpreter.ports)+len(in.interpreter.readers), 0, "test#"+strconv.Itoa(test))
+}
+
+func TestRead(t *testing.T) {
+	test := 0 // several reads don't lose input
+	out := bytes.NewBufferString("")
+	in := strings.NewReader("(2) 3\n(1\n2) x\n\nline\n")
+	res, err := ExecuteTo(`(cons (read) (cons (read) (cons (read) (cons (read-line) (cons (read) nil)))))`, out, out, in)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.ToString(), "(((2) 3) ((1 2) x) nil line nil)", "test#"+strconv.Itoa(test))
+
+	test++ // 1 read from the port
+	res, err = ExecuteTo(`(define in (open-input-string "a (b c)\nd")) (cons (read in) (cons (read-char in) (read in)))`, out, out, in)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.ToString(), "((a (b c)) d)", "test#"+strconv.Itoa(test))
+
+	test++ // 2 syntax error
+	res, err = ExecuteTo(`(read (open-input-string ") a"))`, out, out, in)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Type, ex.Fatal, "test#"+strconv.Itoa(test))
+}
+
+func TestWriteDisplay(t *testing.T) {
+	test := 0 // write quotes symbols
+	res, err := Execute(`(write '(|hello world| "|a" |12| 12 () 'x))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Stdout, `(|hello world| '|\|a| |12| 12 () 'x)`, "test#"+strconv.Itoa(test))
+
+	test++ // 1 display writes symbols as they are
+	res, err = Execute(`(display "hello world") (display '|a;b|)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Stdout, "hello worlda;b", "test#"+strconv.Itoa(test))
+
+	test++ // 2 written expression is read back
+	res, err = Execute(`(define out (open-output-string)) (write '(|a b| "c\nd" |;|) out) (car (read (open-input-string (get-output-string out))))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("a b").Cons(ex.NewFunction("quote").Cons(ex.NewSymbol("c\nd").ToList()).Cons(ex.NewSymbol(";").ToList()))), true, "test#"+strconv.Itoa(test))
+}
+
+func TestFormat(t *testing.T) {
+	test := 0 // directives
+	res, err := Execute(`(format "~a and ~s: ~,2f~%~~" "hello world" "hello world" 3.14159)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("hello world and |hello world|: 3.14\n~")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 1 padding
+	res, err = Execute(`(format "[~6a][~6@a][~8,3f][~3f]" 'ab 'cd 2.5 12.25)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("[ab    ][    cd][   2.500][12.25]")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 2 destinations
+	res, err = Execute(`(define out (open-output-string)) (format out "~a-~a" 1 2) (format T "x=~a" '(1 2)) (get-output-string out)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("1-2")), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, res.Stdout, "x=(1 2)", "test#"+strconv.Itoa(test))
+
+	test++ // 3 not enough arguments
+	res, err = Execute(`(format nil "~a ~a" 1)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("format: not enough arguments for '~a'")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 errors are caught
+	res, err = Execute(`(catch (format "~f" 'x) (format 'bad))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("bad")), true, "test#"+strconv.Itoa(test))
+
+	test++ // 5 unknown directive and extra arguments
+	res, err = Execute(`(cons (catch (format "~q") (default 'unknown)) (cons (catch (format "~a" 1 2) (default 'extra)) nil))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.ToString(), "(unknown extra)", "test#"+strconv.Itoa(test))
+
+	test++ // 6 T without control string is the control string
+	res, err = Execute(`(format "T")`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("T")), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, res.Stdout, "", "test#"+strconv.Itoa(test))
+
+	test++ // 7 width and precision are limited, negative width isn't a directive
+	res, err = Execute(`(format "~65537a" 1)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("format: wrong number 65537 in directive, it must be at most 65536")), true, "test#"+strconv.Itoa(test))
+	res, err = Execute(`(format "~,99999999999999999999f" 1)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Type, ex.Fatal, "test#"+strconv.Itoa(test))
+	res, err = Execute(`(format "~-5a" 1)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewFatal("format: unknown directive '~-'")), true, "test#"+strconv.Itoa(test))
+}
+
+func TestPrettyPrint(t *testing.T) {
+	test := 0 // short list is written on one line
+	res, err := Execute(`(pretty-print '(a "b c" (d)))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Stdout, "(a '|b c| (d))\n", "test#"+strconv.Itoa(test))
+
+	test++ // 1 special forms and calls
+	res, err = Execute(`(pretty-print '(define fact (lambda (n acc) (if (= n 0) acc (fact (- n 1) (* n acc 'some-long-symbol-name 'and-another-one))))))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Stdout, `(define fact
+  (lambda (n acc)
+    (if (= n 0)
+        acc
+        (fact (- n 1) (* n acc 'some-long-symbol-name 'and-another-one)))))
+`, "test#"+strconv.Itoa(test))
+
+	test++ // 2 width
+	expr, err := parser.NewParser(`(let loop ((i 0) (acc nil)) (when (< i 10) (loop (+ i 1) (cons i acc))))`).Parse()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ex.Pretty(expr.Car(), 30), `(let loop ((i 0) (acc nil))
+  (when (< i 10)
+    (loop (+ i 1)
+          (cons i acc))))`, "test#"+strconv.Itoa(test))
+
+	test++ // 3 data list
+	expr, err = parser.NewParser(`((first item of the list) (second item of the list))`).Parse()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ex.Pretty(expr.Car(), 30), `((first item of the list)
+ (second item of the list))`, "test#"+strconv.Itoa(test))
+
+	test++ // 4 no trailing space after long name
+	expr, err = parser.NewParser(`(some-very-long-function-name argument-one argument-two)`).Parse()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ex.Pretty(expr.Car(), 30), `(some-very-long-function-name
+  argument-one
+  argument-two)`, "test#"+strconv.Itoa(test))
+
+	test++ // 5 branches of if are aligned with the test even at narrow width
+	expr, err = parser.NewParser(`(if (null? xs) 'empty (car xs))`).Parse()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ex.Pretty(expr.Car(), 20), `(if (null? xs)
+    'empty
+    (car xs))`, "test#"+strconv.Itoa(test))
+
+	test++ // 6 stack trace prints the value of the error and called lists
+	res, err = Execute(`(throw 'oops '(some data)) ((1 2 3) 4)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, strings.HasPrefix(res.Stderr, "FATAL: oops\n  (some data)\n"), true, "test#"+strconv.Itoa(test))
+	res, err = Execute(`((cons 1 nil) (car 1))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, strings.Contains(res.Stderr, "List [1]\n  (1)\n"), true, "test#"+strconv.Itoa(test))
+
+	test++ // 7 list of the width fits exactly, widths of quotes, commas and quoted symbols are counted
+	expr, err = parser.NewParser(`(f 'a ,b |c d| () "e" (g ,(h 'жж)))`).Parse()
+	assert.Equal(t, err, nil)
+	flat := expr.Car().ReadableString()
+	assert.Equal(t, ex.Pretty(expr.Car(), utf8.RuneCountInString(flat)), flat, "test#"+strconv.Itoa(test))
+	assert.Equal(t, ex.Pretty(expr.Car(), utf8.RuneCountInString(flat)-1) != flat, true, "test#"+strconv.Itoa(test))
+}
+
+func TestScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lispxs")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "script.lxs")
+	assert.Equal(t, ioutil.WriteFile(script, []byte("#!/usr/bin/env lispxs\n(load \"data.lisp\")\n(display (cdr (command-line)))\n(catch (exit 3) (default 'caught))\n(display 'unreachable)"), 0644), nil)
+	assert.Equal(t, ioutil.WriteFile(filepath.Join(dir, "data.lisp"), []byte("1 2"), 0644), nil)
+
+	test := 0 // shebang, arguments and exit
+	out := bytes.NewBufferString("")
+	res, err := ExecuteFile(script, []string{"a", "b c"}, out, out, os.Stdin)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, out.String(), "(a b c)", "test#"+strconv.Itoa(test))
+	assert.Equal(t, ExitCode(res), 3, "test#"+strconv.Itoa(test))
+
+	test++ // 1 exit codes
+	res, err = ExecuteTo(`(car 1)`, out, out, os.Stdin)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ExitCode(res), 1, "test#"+strconv.Itoa(test))
+	res, err = ExecuteTo(`(exit)`, out, out, os.Stdin)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ExitCode(res), 0, "test#"+strconv.Itoa(test))
+	res, err = ExecuteTo(`'x`, out, out, os.Stdin)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ExitCode(res), 0, "test#"+strconv.Itoa(test))
+
+	test++ // 2 exit from a nested run isn't caught
+	res, err = ExecuteTo(`(catch (call-with-port (open-input-string "") (lambda (p) (exit 5))) (default 0))`, out, out, os.Stdin)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ExitCode(res), 5, "test#"+strconv.Itoa(test))
+
+	test++ // 3 wrong code
+	res, err = ExecuteTo(`(exit 'a)`, out, out, os.Stdin)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Equal(ex.NewFatal("exit: code must be an integer from 0 to 255, given a")), true, "test#"+strconv.Itoa(test))
+	res, err = ExecuteTo(`(exit 256)`, out, out, os.Stdin)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Equal(ex.NewFatal("exit: code must be an integer from 0 to 255, given 256")), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, ExitCode(res), 1, "test#"+strconv.Itoa(test))
+
+	test++ // 4 error thrown with exit tag isn't an exit
+	res, err = ExecuteTo(`(throw 'exit -1)`, out, out, os.Stdin)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ExitCode(res), 1, "test#"+strconv.Itoa(test))
+	res, err = ExecuteTo(`(throw 'exit 3)`, out, out, os.Stdin)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.IsExit(), false, "test#"+strconv.Itoa(test))
+	assert.Equal(t, ExitCode(res), 1, "test#"+strconv.Itoa(test))
+
+	test++ // 5 command line of a program which isn't a script
+	res, err = ExecuteTo(`(command-line)`, out, out, os.Stdin)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.IsNil(), true, "test#"+strconv.Itoa(test))
+}
+
+func TestOutputJSON(t *testing.T) {
+	test := 0 // result
+	res, err := Execute(`(display "hi") '(a "b c")`)
+	assert.Equal(t, err, nil)
+	data, err := json.Marshal(res)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(data), `{"result":"(a '|b c|)","stdout":"hi","stderr":"","fatal":null}`, "test#"+strconv.Itoa(test))
+
+	test++ // 1 fatal
+	res, err = Execute(`(throw 'oops '(1 2))`)
+	assert.Equal(t, err, nil)
+	var out struct {
+		Result *string
+		Fatal  struct {
+			Tag, Payload string
+			Trace        []string
+		}
+	}
+	data, err = json.Marshal(res)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, json.Unmarshal(data, &out), nil)
+	assert.Equal(t, out.Result == nil, true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, out.Fatal.Tag, "oops", "test#"+strconv.Itoa(test))
+	assert.Equal(t, out.Fatal.Payload, "(1 2)", "test#"+strconv.Itoa(test))
+	assert.Equal(t, len(out.Fatal.Trace) > 0, true, "test#"+strconv.Itoa(test))
+}
+
+func TestPersistentInterpreter(t *testing.T) {
+	out := bytes.NewBufferString("")
+	interp, err := New(out, out, strings.NewReader(""))
+	assert.Equal(t, err, nil)
+	defer interp.Close()
+
+	test := 0 // definitions are kept between programs
+	_, err = interp.EvalString(`(define x 5) (define in (open-input-string "a\nb"))`)
+	assert.Equal(t, err, nil)
+	res, err := interp.EvalString(`(read-line in)`)
+	assert.Equal(t, err, nil)
+	res, err = interp.EvalString(`(cons x (cons (read-line in) nil))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.ToString(), "(5 b)", "test#"+strconv.Itoa(test))
+
+	test++ // 1 interruption isn't caught
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		interp.Interrupt()
+	}()
+	res, err = interp.EvalString(`(catch (while T) (default 'caught))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Equal(ex.NewFatal(InterruptTag)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 2 the interpreter works after interruption
+	res, err = interp.EvalString(`(catch (car 1) (default (+ x 1)))`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Equal(ex.NewNumber(6)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 3 interruption requested before Eval stops it unless it is reset
+	interp.Interrupt()
+	res, err = interp.EvalString(`(while T)`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Equal(ex.NewFatal(InterruptTag)), true, "test#"+strconv.Itoa(test))
+	interp.Interrupt()
+	interp.ResetInterrupt()
+	res, err = interp.EvalString(`x`)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Equal(ex.NewNumber(5)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 incomplete programs
+	assert.Equal(t, parser.Incomplete("(define x\n  (+ 1"), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, parser.Incomplete("'"), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, parser.Incomplete("(+ 1 2)"), false, "test#"+strconv.Itoa(test))
+	assert.Equal(t, parser.Incomplete("(+ 1 2))"), false, "test#"+strconv.Itoa(test))
+}
+
+func TestCommands(t *testing.T) {
+	out := bytes.NewBufferString("")
+	interp, err := New(out, out, strings.NewReader(""))
+	assert.Equal(t, err, nil)
+	defer interp.Close()
+
+	command := func(src string) string {
+		out.Reset()
+		_, err := interp.Command(src, out)
+		assert.Equal(t, err, nil, src)
+		return out.String()
+	}
+
+	_, err = interp.EvalString(`(defmacro twice (x) (cons 'begin (cons x (cons x nil))))
+		(define fact (lambda (n) (if (< n 2) 1 (* n (fact (- n 1))))))`)
+	assert.Equal(t, err, nil)
+
+	test := 0 // :type
+	assert.Equal(t, command(":type (fact 3)"), "number\n", "test#"+strconv.Itoa(test))
+	assert.Equal(t, command(`:type "str"`), "symbol\n", "test#"+strconv.Itoa(test))
+
+	test++ // 1 :expand expands nested calls
+	assert.Equal(t, command(":expand (twice (twice 1))"), "(begin (begin 1 1) (begin 1 1))\n", "test#"+strconv.Itoa(test))
+
+	test++ // 2 :trace prints calls and results
+	assert.Equal(t, command(":trace fact"), "tracing fact\n", "test#"+strconv.Itoa(test))
+	out.Reset()
+	res, err := interp.EvalString("(catch (fact 'a) (default (fact 2)))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Equal(ex.NewNumber(2)), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, out.String(), "> (fact a)\n> (fact 2)\n  > (fact 1)\n  < 1\n< 2\n", "test#"+strconv.Itoa(test))
+	assert.Equal(t, command(":trace fact"), "stopped tracing fact\n", "test#"+strconv.Itoa(test))
+	_, err = interp.Command(":trace twice", out)
+	assert.Equal(t, err.Error(), "trace: twice is not a closure", "test#"+strconv.Itoa(test))
+
+	test++ // 3 :time
+	assert.Equal(t, strings.HasPrefix(command(":time (fact 3)"), "6\ntime: "), true, "test#"+strconv.Itoa(test))
+	program, err := parser.NewParser("(fact 3)").Parse()
+	assert.Equal(t, err, nil)
+	_, elapsed, steps := interp.Time(program)
+	assert.Equal(t, elapsed > 0 && steps > 0, true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 :env lists defined variables without builtins
+	env := command(":env")
+	assert.Equal(t, strings.Contains(env, "fact = "), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, strings.Contains(env, "car = "), false, "test#"+strconv.Itoa(test))
+	_, err = interp.EvalString("(define long '" + strings.Repeat("ж", 100) + ")")
+	assert.Equal(t, err, nil)
+	env = command(":env")
+	assert.Equal(t, strings.Contains(env, "long = "+strings.Repeat("ж", 70)+"...\n"), true, "test#"+strconv.Itoa(test))
+
+	test++ // 5 :load and :reload
+	dir, err := ioutil.TempDir("", "lispxs")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.lxs")
+	assert.Equal(t, ioutil.WriteFile(path, []byte("(define y 1)"), 0644), nil)
+	assert.Equal(t, command(":load "+path), "loaded "+path+"\n", "test#"+strconv.Itoa(test))
+	assert.Equal(t, ioutil.WriteFile(path, []byte("(define y 2)"), 0644), nil)
+	command(":reload")
+	res, _ = interp.EvalString("y")
+	assert.Equal(t, res.Equal(ex.NewNumber(2)), true, "test#"+strconv.Itoa(test))
+
+	test++ // 6 :quit and other words
+	_, err = interp.Command(":quit", out)
+	assert.Equal(t, err, ErrQuit, "test#"+strconv.Itoa(test))
+	assert.Equal(t, IsCommand("  :time (+ 1 2)"), true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, IsCommand(":key"), false, "test#"+strconv.Itoa(test))
+}
+
+func TestCompletion(t *testing.T) {
+	interp, err := New(ioutil.Discard, ioutil.Discard, strings.NewReader(""))
+	assert.Equal(t, err, nil)
+	defer interp.Close()
+
+	_, err = interp.EvalString("(define reverse-all 1)")
+	assert.Equal(t, err, nil)
+
+	test := 0 // defined variables and builtin functions
+	assert.Equal(t, interp.Complete("revers"), []string{"reverse", "reverse-all"}, "test#"+strconv.Itoa(test))
+	assert.Equal(t, interp.Complete("no-such-"), []string(nil), "test#"+strconv.Itoa(test))
+
+	test++ // 1 the closest name is suggested
+	res, err := Execute("(define counter 1) (lenght (quote (1 2)))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.String, "call: symbol 'lenght' is not defined, did you mean 'length'?", "test#"+strconv.Itoa(test))
+	res, err = Execute("(define counter 1) (+ 1 countr)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.String, "call: symbol 'countr' is not defined, did you mean 'counter'?", "test#"+strconv.Itoa(test))
+
+	test++ // 2 nothing is suggested for different names
+	res, err = Execute("(qwerty 1)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.String, "call: symbol 'qwerty' is not defined", "test#"+strconv.Itoa(test))
+	res, err = Execute("(+ x 1)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.String, "call: symbol 'x' is not defined", "test#"+strconv.Itoa(test))
+
+	test++ // 3 names of the scope where the symbol isn't found are suggested
+	res, err = Execute("(define f (lambda (counter) (+ countr 1))) (f 1)")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.String, "call: symbol 'countr' is not defined, did you mean 'counter'?", "test#"+strconv.Itoa(test))
+	assert.Equal(t, strings.HasPrefix(res.Stderr, "FATAL: call: symbol 'countr' is not defined, did you mean 'counter'?\n"), true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 caught errors aren't completed by suggestions
+	res, err = Execute("(define counter 1) (catch countr (default error_description))")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.Output.Equal(ex.NewSymbol("call: symbol 'countr' is not defined")), true, "test#"+strconv.Itoa(test))
+}
+
+func TestServeREPL(t *testing.T) {
+	interp, err := New(ioutil.Discard, ioutil.Discard, strings.NewReader(""))
+	assert.Equal(t, err, nil)
+	defer interp.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, err, nil)
+	defer l.Close()
+	go func() { _ = ServeREPL(l, interp) }()
+
+	connect := func() (net.Conn, int64) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		assert.Equal(t, err, nil)
+
+		hello := &Response{}
+		assert.Equal(t, ReadFrame(conn, hello), nil)
+		return conn, hello.Session
+	}
+
+	request := func(conn net.Conn, req *Request) *Response {
+		assert.Equal(t, WriteFrame(conn, req), nil)
+
+		res := &Response{}
+		assert.Equal(t, ReadFrame(conn, res), nil)
+		return res
+	}
+
+	first, firstID := connect()
+	defer first.Close()
+	second, secondID := connect()
+	defer second.Close()
+
+	test := 0 // sessions share variables, output is sent to the session
+	res := request(first, &Request{ID: 1, Op: OpEval, Code: `(define x 5) (display "one")`})
+	assert.Equal(t, res.ID, int64(1), "test#"+strconv.Itoa(test))
+	assert.Equal(t, res.Stdout, "one", "test#"+strconv.Itoa(test))
+	res = request(second, &Request{ID: 1, Op: OpEval, Code: `(display "two") (+ x 1)`})
+	assert.Equal(t, res.Stdout, "two", "test#"+strconv.Itoa(test))
+	assert.Equal(t, *res.Result, "6", "test#"+strconv.Itoa(test))
+
+	test++ // 1 errors are sent with stack traces
+	res = request(second, &Request{ID: 2, Op: OpEval, Code: `(car 1)`})
+	assert.Equal(t, res.Result == nil && res.Fatal.Tag == "car: object must be pair", true, "test#"+strconv.Itoa(test))
+	assert.Equal(t, strings.HasPrefix(res.Stderr, "FATAL: car"), true, "test#"+strconv.Itoa(test))
+
+	test++ // 2 REPL commands
+	res = request(second, &Request{ID: 3, Op: OpEval, Code: `:type x`})
+	assert.Equal(t, res.Stdout, "number\n", "test#"+strconv.Itoa(test))
+	assert.Equal(t, res.Result == nil, true, "test#"+strconv.Itoa(test))
+
+	test++ // 3 a session interrupts another one
+	res = request(second, &Request{ID: 4, Op: OpInterrupt, Session: firstID})
+	assert.Equal(t, res.Error, "session "+strconv.Itoa(int(firstID))+" isn't calculating", "test#"+strconv.Itoa(test))
+
+	assert.Equal(t, WriteFrame(first, &Request{ID: 2, Op: OpEval, Code: `(catch (while T) (default 'caught))`}), nil)
+	for {
+		time.Sleep(20 * time.Millisecond)
+		if res = request(second, &Request{ID: 5, Op: OpInterrupt, Session: firstID}); res.Error == "" {
+			break
+		}
+	}
+
+	res = &Response{}
+	assert.Equal(t, ReadFrame(first, res), nil)
+	assert.Equal(t, res.ID, int64(2), "test#"+strconv.Itoa(test))
+	assert.Equal(t, res.Fatal.Tag, InterruptTag, "test#"+strconv.Itoa(test))
+	assert.Equal(t, secondID != firstID, true, "test#"+strconv.Itoa(test))
+
+	test++ // 4 the interpreter works after interruption
+	res = request(first, &Request{ID: 3, Op: OpEval, Code: `x`})
+	assert.Equal(t, *res.Result, "5", "test#"+strconv.Itoa(test))
+
+	test++ // 5 requests left in the queue of the disconnected session are dropped
+	third, _ := connect()
+	assert.Equal(t, WriteFrame(third, &Request{ID: 1, Op: OpEval, Code: `(while T)`}), nil)
+	assert.Equal(t, WriteFrame(third, &Request{ID: 2, Op: OpEval, Code: `(define dropped 1)`}), nil)
+	third.Close()
+	res = request(first, &Request{ID: 4, Op: OpEval, Code: `(catch dropped (default 'none))`})
+	assert.Equal(t, *res.Result, "none", "test#"+strconv.Itoa(test))
+
+	test++ // 6 requests above the full queue are answered by an error, interruptions are still read
+	fourth, _ := connect()
+	defer fourth.Close()
+	assert.Equal(t, WriteFrame(fourth, &Request{ID: 1, Op: OpEval, Code: `(while T)`}), nil)
+	for id := int64(2); id <= maxQueued+2; id++ {
+		assert.Equal(t, WriteFrame(fourth, &Request{ID: id, Op: OpEval, Code: `1`}), nil)
+	}
+
+	evals, rejected, interrupted := 0, 0, false
+	count := func(res *Response) {
+		evals++
+		if res.Error == "too many queued requests" {
+			rejected++
+		}
+		if res.ID == 1 && res.Fatal != nil && res.Fatal.Tag == InterruptTag {
+			interrupted = true
+		}
+	}
+
+	// the session is interrupted by its own request sent after the full queue
+	for stopped := false; !stopped; {
+		time.Sleep(20 * time.Millisecond)
+		assert.Equal(t, WriteFrame(fourth, &Request{ID: 100, Op: OpInterrupt}), nil)
+		for {
+			res = &Response{}
+			assert.Equal(t, ReadFrame(fourth, res), nil)
+			if res.ID == 100 {
+				stopped = res.Error == ""
+				break
+			}
+			count(res)
+		}
+	}
+
+	for evals < maxQueued+2 {
+		res = &Response{}
+		assert.Equal(t, ReadFrame(fourth, res), nil)
+		count(res)
+	}
+	assert.Equal(t, rejected > 0 && interrupted, true, "test#"+strconv.Itoa(test))
+}
@@ -0,0 +1,85 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	ex "github.com/batrSens/LispXS/expressions"
+)
+
+func listToSlice(list *ex.Expr) ([]*ex.Expr, bool) {
+	var res []*ex.Expr
+	for ; list.Type == ex.Pair; list = list.Cdr() {
+		res = append(res, list.Car())
+	}
+
+	return res, list.IsNil()
+}
+
+func sliceToList(exprs []*ex.Expr) *ex.Expr {
+	res := ex.NewNil()
+	for i := len(exprs) - 1; i >= 0; i-- {
+		res = exprs[i].Cons(res)
+	}
+
+	return res
+}
+
+func isList(e *ex.Expr) bool {
+	return e.Type == ex.Pair || e.IsNil()
+}
+
+// indexArg checks that arg is an integer number from 0 to max.
+func indexArg(name string, arg *ex.Expr, max int) (int, *ex.Expr) {
+	if arg.Type != ex.Number || arg.Number != math.Trunc(arg.Number) {
+		return 0, ex.NewFatal(name + ": index must be an integer number, given " + arg.ToString())
+	}
+
+	if arg.Number < 0 || arg.Number > float64(max) {
+		return 0, ex.NewFatal(fmt.Sprintf("%s: index %s is out of range [0, %d]", name, arg.ToString(), max))
+	}
+
+	return int(arg.Number), nil
+}
+
+func init() {
+	// sort calls the comparator, so it can't be defined in the functions' declaration
+	functions["sort"] = Func{
+		Doc: "(sort list less) - list stably sorted by less called with two elements.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 2 {
+				return ex.NewFatal("sort: must be 2 arguments")
+			}
+
+			list, ok := listToSlice(args[0])
+			if !ok {
+				return ex.NewFatal("sort: first argument must be a list")
+			}
+
+			if !isCallable(args[1]) {
+				return ex.NewFatal("sort: second argument must be a function or closure, given " + args[1].ToString())
+			}
+
+			var fatal *ex.Expr
+			sort.SliceStable(list, func(i, j int) bool {
+				if fatal != nil {
+					return false
+				}
+
+				less := ir.call(args[1], []*ex.Expr{list[i], list[j]})
+				if less.Type == ex.Fatal {
+					fatal = less
+				}
+
+				return !less.IsNil()
+			})
+
+			if fatal != nil {
+				return fatal
+			}
+
+			return sliceToList(list)
+		},
+	}
+}
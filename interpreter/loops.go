@@ -0,0 +1,249 @@
+package interpreter
+
+import (
+	ex "github.com/batrSens/LispXS/expressions"
+)
+
+const (
+	loopInit = iota
+	loopTest
+	loopBody
+	loopStep
+	loopResult
+)
+
+// loop is a state of do, while or for. Every part of the loop (test, body, steps) is a list that ends with
+// the marker; when the marker is reached, values of the part are popped from the data stack and the next part
+// replaces the control, so the loop is calculated in constant space of both stacks.
+type loop struct {
+	names   []string
+	stepped []int
+	test    *ex.Expr
+	until   bool
+	result  *ex.Expr
+	body    *ex.Expr
+	steps   *ex.Expr
+	stage   int
+	marker  *ex.Expr
+}
+
+func newLoop(body *ex.Expr) *loop {
+	l := &loop{marker: ex.NewNil(), result: ex.NewNil()}
+	l.body = l.withMarker(body)
+	return l
+}
+
+func (l *loop) withMarker(exprs *ex.Expr) *ex.Expr {
+	var list []*ex.Expr
+	for cur := exprs; cur.Type == ex.Pair; cur = cur.Cdr() {
+		list = append(list, cur.Car())
+	}
+
+	res := l.marker.ToList()
+	for i := len(list) - 1; i >= 0; i-- {
+		res = list[i].Cons(res)
+	}
+
+	return res
+}
+
+func modLoop(ir *interpreter) bool {
+	l := ir.mod.Loop
+	if l == nil {
+		switch ir.mod.Type {
+		case ModDo:
+			return doInit(ir)
+		case ModFor:
+			return forInit(ir)
+		default:
+			l = newLoop(ir.control.Cdr())
+			l.test = l.withMarker(ir.getCurSymbol().ToList())
+			ir.mod = &Mod{Type: ModWhile, Loop: l}
+			return l.next(ir, loopTest, l.test)
+		}
+	}
+
+	if ir.getCurSymbol() != l.marker {
+		return false
+	}
+
+	switch l.stage {
+	case loopInit:
+		if ir.mod.Type == ModFor {
+			return forBind(ir, l)
+		}
+
+		vars := ex.NewVarsWithParent(ir.varsEnvironment)
+		for i, v := range ir.dataStack.Top(len(l.names)) {
+			vars.CurSymbols[l.names[i]] = v
+		}
+
+		ir.setNewVars(vars)
+		return l.next(ir, loopTest, l.test)
+
+	case loopTest:
+		if ir.dataStack.Last().IsNil() != l.until {
+			if l.result.IsNil() {
+				l.stage = loopResult
+				ir.control = l.marker.ToList()
+				ir.dataStack.Push(ex.NewNil())
+				return true
+			}
+
+			return l.next(ir, loopResult, l.result)
+		}
+
+		return l.next(ir, loopBody, l.body)
+
+	case loopBody:
+		if l.steps == nil {
+			return l.next(ir, loopTest, l.test)
+		}
+
+		return l.next(ir, loopStep, l.steps)
+
+	case loopStep:
+		// every iteration has its own scope, so closures that are created in the body keep their values
+		vars := ex.NewVarsWithParent(ir.varsEnvironment.Parent)
+		for k, v := range ir.varsEnvironment.CurSymbols {
+			vars.CurSymbols[k] = v
+		}
+
+		for i, v := range ir.dataStack.Top(len(l.stepped)) {
+			vars.CurSymbols[l.names[l.stepped[i]]] = v
+		}
+
+		ir.varsEnvironment = vars
+		return l.next(ir, loopTest, l.test)
+	}
+
+	panic("unexpected loop stage")
+}
+
+// next drops values of the current part of the loop and continues with the next one.
+func (l *loop) next(ir *interpreter, stage int, control *ex.Expr) bool {
+	for ir.argsNum > 2 {
+		ir.dataStack.Pop()
+		ir.argsNum--
+	}
+
+	l.stage = stage
+	ir.control = control
+
+	if ir.getCurSymbol() == l.marker {
+		return modLoop(ir)
+	}
+
+	return false
+}
+
+// doInit parses '(do ((var init step)...) (test result...) body...)'. Init expressions are inserted
+// into the control after the specs like in let.
+func doInit(ir *interpreter) bool {
+	specs := ir.getCurSymbol()
+
+	clause := ir.control.Cdr()
+	if clause.Type != ex.Pair || clause.Car().Type != ex.Pair {
+		ir.dataStack.Push(ex.NewFatal("do: second argument must be a list with test and results"))
+		return true
+	}
+
+	l := newLoop(clause.Cdr())
+	l.test = l.withMarker(clause.Car().Car().ToList())
+	l.result = clause.Car().Cdr()
+	l.until = true
+
+	var inits, steps []*ex.Expr
+	exists := map[string]struct{}{}
+
+	for cur := specs; !cur.IsNil(); cur = cur.Cdr() {
+		if cur.Type != ex.Pair {
+			ir.dataStack.Push(ex.NewFatal("do: specs must be a list"))
+			return true
+		}
+
+		spec := cur.Car()
+		if length := spec.Length(); spec.Type != ex.Pair || spec.Car().Type != ex.Symbol || length != 2 && length != 3 {
+			ir.dataStack.Push(ex.NewFatal("do: spec must be a list of symbol, init and optional step, given " + spec.ToString()))
+			return true
+		}
+
+		name := spec.Car().String
+		if _, ok := exists[name]; ok {
+			ir.dataStack.Push(ex.NewFatal("do: all variables must be a different"))
+			return true
+		}
+
+		exists[name] = struct{}{}
+		inits = append(inits, spec.Index(1))
+		if spec.Length() == 3 {
+			l.stepped = append(l.stepped, len(l.names))
+			steps = append(steps, spec.Index(2))
+		}
+
+		l.names = append(l.names, name)
+	}
+
+	if len(steps) > 0 {
+		l.steps = l.withMarker(sliceToList(steps))
+	}
+
+	ir.mod = &Mod{Type: ModDo, Loop: l}
+	ir.control = specs.Cons(l.withMarker(sliceToList(inits)))
+	ir.dataStack.Push(specs)
+	return true
+}
+
+// forInit parses '(for (var from to step) body...)', step is optional and equals 1 by default.
+func forInit(ir *interpreter) bool {
+	spec := ir.getCurSymbol()
+	if length := spec.Length(); spec.Type != ex.Pair || spec.Car().Type != ex.Symbol || length != 3 && length != 4 {
+		ir.dataStack.Push(ex.NewFatal("for: first argument must be a list of symbol, start, end and optional step"))
+		return true
+	}
+
+	l := newLoop(ir.control.Cdr())
+	l.names = []string{spec.Car().String}
+	l.stepped = []int{0}
+
+	step := ex.NewNumber(1)
+	if spec.Length() == 4 {
+		step = spec.Index(3)
+	}
+
+	ir.mod = &Mod{Type: ModFor, Loop: l}
+	ir.control = spec.Cons(l.withMarker(sliceToList([]*ex.Expr{spec.Index(1), spec.Index(2), step})))
+	ir.dataStack.Push(spec)
+	return true
+}
+
+func forBind(ir *interpreter, l *loop) bool {
+	values := ir.dataStack.Top(3)
+	for _, v := range values {
+		if v.Type != ex.Number {
+			ir.dataStack.Push(ex.NewFatal("for: expected numbers, given " + v.ToString()))
+			return true
+		}
+	}
+
+	from, to, step := values[0], values[1], values[2]
+	if step.Number == 0 {
+		ir.dataStack.Push(ex.NewFatal("for: zero step"))
+		return true
+	}
+
+	cmp := "<"
+	if step.Number < 0 {
+		cmp = ">"
+	}
+
+	name := ex.NewSymbol(l.names[0])
+	l.test = l.withMarker(sliceToList([]*ex.Expr{sliceToList([]*ex.Expr{ex.NewFunction(cmp), name, to})}))
+	l.steps = l.withMarker(sliceToList([]*ex.Expr{sliceToList([]*ex.Expr{ex.NewFunction("+"), name, step})}))
+
+	vars := ex.NewVarsWithParent(ir.varsEnvironment)
+	vars.CurSymbols[l.names[0]] = from
+
+	ir.setNewVars(vars)
+	return l.next(ir, loopTest, l.test)
+}
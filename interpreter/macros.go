@@ -0,0 +1,414 @@
+package interpreter
+
+import (
+	"errors"
+
+	ex "github.com/batrSens/LispXS/expressions"
+)
+
+// expandMacro runs body of the macro if form is a macro call and returns generated code without calculating it.
+// Arguments preceded by a comma are calculated before it as in usual macro call. The second result reports whether
+// form is a macro call. Symbols are resolved in vars.
+func (ir *interpreter) expandMacro(form *ex.Expr, vars *ex.Vars) (*ex.Expr, bool) {
+	if form.Type != ex.Pair {
+		return form, false
+	}
+
+	macro := form.Car()
+	if macro.Type == ex.Symbol {
+		macro, _ = vars.Lookup(macro.String)
+	}
+
+	if macro == nil || macro.Type != ex.Macro {
+		return form, false
+	}
+
+	if macro.Rules != nil {
+		return ir.expandSyntax(macro, form.Cdr()), true
+	}
+
+	args, _ := listToSlice(form.Cdr())
+	exec := macro.MacroExecMod()
+
+	for i, arg := range args {
+		if _, ok := exec[i+1]; ok || exec == nil {
+			args[i] = ir.eval(arg.ToList(), vars)
+			if args[i].Type == ex.Fatal {
+				return args[i], true
+			}
+		}
+	}
+
+	macroVars, err := macro.NewClosureVars(args)
+	if err != nil {
+		return ex.NewFatal(err.Error()), true
+	}
+
+	return ir.eval(macro.ClosureBody().ToList(), macroVars), true
+}
+
+// gensym returns a new uninterned symbol, it isn't equal to symbols of the source and other generated ones.
+func (ir *interpreter) gensym(prefix string) *ex.Expr {
+	return ex.NewUninternedSymbol(prefix)
+}
+
+// syntaxMatch is a value of pattern variable. Variables followed by an ellipsis have a sequence of values.
+type syntaxMatch struct {
+	expr  *ex.Expr
+	seq   []*syntaxMatch
+	isSeq bool
+}
+
+type syntaxBindings map[string]*syntaxMatch
+
+func isEllipsis(e *ex.Expr) bool {
+	return e.Type == ex.Symbol && e.String == "..."
+}
+
+// expandSyntax finds the first rule of the macro whose pattern matches arguments and fills its template.
+func (ir *interpreter) expandSyntax(macro *ex.Expr, args *ex.Expr) *ex.Expr {
+	literals := map[string]struct{}{}
+	for cur := macro.Rules.Car(); !cur.IsNil(); cur = cur.Cdr() {
+		literals[cur.Car().String] = struct{}{}
+	}
+
+	for cur := macro.Rules.Cdr(); !cur.IsNil(); cur = cur.Cdr() {
+		pattern, template := cur.Car().Car(), cur.Car().Cdr().Car()
+
+		b := syntaxBindings{}
+		if !matchSyntax(pattern.Cdr(), args, literals, b) {
+			continue
+		}
+
+		exp := &syntaxExpander{ir: ir, renames: map[string]*ex.Expr{}}
+		exp.collectBinders(template, b)
+
+		res, err := exp.fill(template, b, false)
+		if err != nil {
+			return ex.NewFatal(err.Error())
+		}
+
+		return res
+	}
+
+	return ex.NewFatal("syntax-rules: no suitable pattern for " + args.ToString())
+}
+
+func matchSyntax(pattern, form *ex.Expr, literals map[string]struct{}, b syntaxBindings) bool {
+	switch pattern.Type {
+	case ex.Symbol:
+		if _, ok := literals[pattern.String]; ok {
+			return form.Type == ex.Symbol && form.String == pattern.String
+		}
+
+		if pattern.String != "_" {
+			b[pattern.String] = &syntaxMatch{expr: form}
+		}
+
+		return true
+
+	case ex.Pair:
+		if !isList(form) {
+			return false
+		}
+
+		patterns, _ := listToSlice(pattern)
+		forms, _ := listToSlice(form)
+
+		ellipsis := -1
+		for i, p := range patterns {
+			if isEllipsis(p) && i > 0 {
+				ellipsis = i
+				break
+			}
+		}
+
+		if ellipsis < 0 {
+			if len(patterns) != len(forms) {
+				return false
+			}
+
+			for i, p := range patterns {
+				if !matchSyntax(p, forms[i], literals, b) {
+					return false
+				}
+			}
+
+			return true
+		}
+
+		before, repeated, after := patterns[:ellipsis-1], patterns[ellipsis-1], patterns[ellipsis+1:]
+		n := len(forms) - len(before) - len(after)
+		if n < 0 {
+			return false
+		}
+
+		for i, p := range before {
+			if !matchSyntax(p, forms[i], literals, b) {
+				return false
+			}
+		}
+
+		for i, p := range after {
+			if !matchSyntax(p, forms[len(before)+n+i], literals, b) {
+				return false
+			}
+		}
+
+		var seq []syntaxBindings
+		for _, f := range forms[len(before) : len(before)+n] {
+			cur := syntaxBindings{}
+			if !matchSyntax(repeated, f, literals, cur) {
+				return false
+			}
+
+			seq = append(seq, cur)
+		}
+
+		for _, v := range patternVars(repeated, literals) {
+			m := &syntaxMatch{isSeq: true}
+			for _, cur := range seq {
+				m.seq = append(m.seq, cur[v])
+			}
+
+			b[v] = m
+		}
+
+		return true
+
+	default:
+		return pattern.Equal(form)
+	}
+}
+
+func patternVars(pattern *ex.Expr, literals map[string]struct{}) []string {
+	switch pattern.Type {
+	case ex.Symbol:
+		if _, ok := literals[pattern.String]; ok || pattern.String == "_" || isEllipsis(pattern) {
+			return nil
+		}
+
+		return []string{pattern.String}
+
+	case ex.Pair:
+		var res []string
+		for cur := pattern; cur.Type == ex.Pair; cur = cur.Cdr() {
+			res = append(res, patternVars(cur.Car(), literals)...)
+		}
+
+		return res
+	}
+
+	return nil
+}
+
+// syntaxExpander fills templates. Variables bound by lambda, let and loops of a template that aren't pattern
+// variables are renamed to new symbols, so bindings introduced by the macro can't capture or clobber variables
+// of the code that uses it.
+type syntaxExpander struct {
+	ir      *interpreter
+	renames map[string]*ex.Expr
+}
+
+func (se *syntaxExpander) fill(template *ex.Expr, b syntaxBindings, quoted bool) (*ex.Expr, error) {
+	switch template.Type {
+	case ex.Symbol:
+		if m, ok := b[template.String]; ok {
+			if m.isSeq {
+				return nil, errors.New("syntax-rules: variable '" + template.String + "' must be followed by an ellipsis")
+			}
+
+			return m.expr, nil
+		}
+
+		if res, ok := se.renames[template.String]; ok && !quoted {
+			return res, nil
+		}
+
+		return template, nil
+
+	case ex.Pair:
+		items, _ := listToSlice(template)
+
+		if isQuote(items[0]) {
+			quoted = true
+		}
+
+		var res []*ex.Expr
+		for i := 0; i < len(items); i++ {
+			if i+1 >= len(items) || !isEllipsis(items[i+1]) {
+				e, err := se.fill(items[i], b, quoted)
+				if err != nil {
+					return nil, err
+				}
+
+				res = append(res, e)
+				continue
+			}
+
+			var vars []string
+			for _, v := range patternVars(items[i], nil) {
+				if m, ok := b[v]; ok && m.isSeq {
+					vars = append(vars, v)
+				}
+			}
+
+			if len(vars) == 0 {
+				return nil, errors.New("syntax-rules: no pattern variables before ellipsis in " + template.ToString())
+			}
+
+			n := len(b[vars[0]].seq)
+			for _, v := range vars {
+				if len(b[v].seq) != n {
+					return nil, errors.New("syntax-rules: variables before ellipsis have different lengths in " + template.ToString())
+				}
+			}
+
+			for k := 0; k < n; k++ {
+				cur := syntaxBindings{}
+				for v, m := range b {
+					cur[v] = m
+				}
+
+				for _, v := range vars {
+					cur[v] = b[v].seq[k]
+				}
+
+				e, err := se.fill(items[i], cur, quoted)
+				if err != nil {
+					return nil, err
+				}
+
+				res = append(res, e)
+			}
+
+			i++
+		}
+
+		return sliceToList(res), nil
+	}
+
+	return template, nil
+}
+
+func isQuote(e *ex.Expr) bool {
+	return (e.Type == ex.Symbol || e.Type == ex.Function) && e.String == "quote"
+}
+
+func (se *syntaxExpander) collectBinders(template *ex.Expr, b syntaxBindings) {
+	if template.Type != ex.Pair || isQuote(template.Car()) {
+		return
+	}
+
+	bind := func(e *ex.Expr) {
+		// markers of optional and keyword parameters aren't binders
+		if e.Type != ex.Symbol || isEllipsis(e) || e.String == "_" || e.String == "#!optional" || e.String == "#:key" {
+			return
+		}
+
+		if _, ok := b[e.String]; ok {
+			return
+		}
+
+		if _, ok := se.renames[e.String]; !ok {
+			se.renames[e.String] = se.ir.gensym(e.String)
+		}
+	}
+
+	bindings := func(e *ex.Expr) {
+		for cur := e; cur.Type == ex.Pair; cur = cur.Cdr() {
+			if cur.Car().Type == ex.Pair {
+				bind(cur.Car().Car())
+			}
+		}
+	}
+
+	if head := template.Car(); head.Type == ex.Symbol || head.Type == ex.Function {
+		second := template.Cdr()
+		if second.Type == ex.Pair {
+			switch head.String {
+			case "lambda":
+				cur := second.Car()
+				for ; cur.Type == ex.Pair; cur = cur.Cdr() {
+					bind(cur.Car())
+				}
+
+				bind(cur)
+
+			case "let", "let*", "letrec":
+				if second.Car().Type == ex.Symbol {
+					bind(second.Car())
+					second = second.Cdr()
+				}
+
+				if second.Type == ex.Pair {
+					bindings(second.Car())
+				}
+
+			case "do":
+				bindings(second.Car())
+
+			case "for":
+				if second.Car().Type == ex.Pair {
+					bind(second.Car().Car())
+				}
+			}
+		}
+	}
+
+	for cur := template; cur.Type == ex.Pair; cur = cur.Cdr() {
+		se.collectBinders(cur.Car(), b)
+	}
+}
+
+func init() {
+	functions["macroexpand-1"] = Func{
+		Doc: "(macroexpand-1 form) - expansion of macro call form without calculation.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 1 {
+				return ex.NewFatal("macroexpand-1: must be 1 argument")
+			}
+
+			res, _ := ir.expandMacro(args[0], ir.varsEnvironment)
+			return res
+		},
+	}
+
+	functions["gensym"] = Func{
+		Doc: "(gensym [prefix]) - new unique symbol.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) > 1 {
+				return ex.NewFatal("gensym: expected zero or one expression")
+			}
+
+			if len(args) == 0 {
+				return ir.gensym("g")
+			}
+
+			if args[0].Type != ex.Symbol {
+				return ex.NewFatal("gensym: prefix must be a symbol")
+			}
+
+			return ir.gensym(args[0].String)
+		},
+	}
+
+	functions["macroexpand"] = Func{
+		Doc: "(macroexpand form) - expands form while it is a macro call.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 1 {
+				return ex.NewFatal("macroexpand: must be 1 argument")
+			}
+
+			form := args[0]
+			for {
+				res, ok := ir.expandMacro(form, ir.varsEnvironment)
+				if !ok || res.Type == ex.Fatal {
+					return res
+				}
+
+				form = res
+			}
+		},
+	}
+}
@@ -0,0 +1,170 @@
+package interpreter
+
+import (
+	"strings"
+
+	ex "github.com/batrSens/LispXS/expressions"
+)
+
+// binding is an exported variable of a module.
+type binding struct {
+	name  string
+	value *ex.Expr
+}
+
+// moduleFile collects bindings exported by modules of the file while it is being required.
+type moduleFile struct {
+	path    string
+	exports []binding
+}
+
+func (ir *interpreter) globalVars() *ex.Vars {
+	vars := ir.varsEnvironment
+	for !vars.IsRoot() {
+		vars = vars.Parent
+	}
+
+	return vars
+}
+
+// module calculates body in its own scope whose parent is the global one and defines exported variables
+// in the current scope.
+func (ir *interpreter) module(name string, exports []string, body *ex.Expr) *ex.Expr {
+	vars := ex.NewVarsWithParent(ir.globalVars())
+
+	res := ir.eval(body, vars)
+	if res.Type == ex.Fatal {
+		return res
+	}
+
+	var bindings []binding
+	for _, e := range exports {
+		value, ok := vars.CurSymbols[e]
+		if !ok {
+			return ex.NewFatal("module: exported symbol '" + e + "' of module '" + name + "' is not defined")
+		}
+
+		bindings = append(bindings, binding{name: e, value: value})
+	}
+
+	for _, b := range bindings {
+		ir.varsEnvironment.CurSymbols[b.name] = b.value
+	}
+
+	if n := len(ir.loading); n > 0 {
+		ir.loading[n-1].exports = append(ir.loading[n-1].exports, bindings...)
+	}
+
+	return ex.NewSymbol(name)
+}
+
+// require calculates the file once and returns bindings exported by its modules.
+func (ir *interpreter) require(path string) ([]binding, *ex.Expr) {
+	abs, err := ir.resolvePath(path)
+	if err != nil {
+		return nil, ex.NewFatal("require: " + err.Error())
+	}
+
+	if exports, ok := ir.modules[abs]; ok {
+		return exports, nil
+	}
+
+	for i, f := range ir.loading {
+		if f.path == abs {
+			var chain []string
+			for _, f := range ir.loading[i:] {
+				chain = append(chain, f.path)
+			}
+
+			return nil, ex.NewFatal("require: circular load " + strings.Join(append(chain, abs), " -> "))
+		}
+	}
+
+	_, program := ir.readFile("require", abs)
+	if program.Type == ex.Fatal {
+		return nil, program
+	}
+
+	ir.loading = append(ir.loading, &moduleFile{path: abs})
+	res := ir.evalFile(abs, program, ex.NewVarsWithParent(ir.globalVars()))
+	loaded := ir.loading[len(ir.loading)-1]
+	ir.loading = ir.loading[:len(ir.loading)-1]
+
+	if res.Type == ex.Fatal {
+		return nil, res
+	}
+
+	ir.modules[abs] = loaded.exports
+	return loaded.exports, nil
+}
+
+func init() {
+	// module and require calculate code by a nested run, so they can't be defined in the functions' declaration
+	functions["module"] = Func{
+		Doc: "(module name (export symbol...) body...) - calculates body in its own scope, only exported variables are visible outside.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) < 2 {
+				return ex.NewFatal("module: must be at least 2 arguments")
+			}
+
+			if args[0].Type != ex.Symbol {
+				return ex.NewFatal("module: name must be a symbol")
+			}
+
+			exports, ok := listToSlice(args[1])
+			if !ok || len(exports) == 0 || exports[0].Type != ex.Symbol || exports[0].String != "export" {
+				return ex.NewFatal("module: second argument must be a list (export symbol...)")
+			}
+
+			var names []string
+			for _, e := range exports[1:] {
+				if e.Type != ex.Symbol {
+					return ex.NewFatal("module: exported names must be symbols, given " + e.ToString())
+				}
+
+				names = append(names, e.String)
+			}
+
+			return ir.module(args[0].String, names, sliceToList(args[2:]))
+		},
+		Mod: &Mod{
+			Type: ModExec,
+			Exec: map[int]struct{}{},
+		},
+	}
+
+	functions["require"] = Func{
+		Doc: "(require path [prefix]) - calculates file once and defines variables exported by its modules with prefix.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 1 && len(args) != 2 {
+				return ex.NewFatal("require: must be 1 or 2 arguments")
+			}
+
+			if args[0].Type != ex.Symbol {
+				return ex.NewFatal("require: path must be a string or symbol")
+			}
+
+			prefix := ""
+			if len(args) == 2 {
+				if args[1].Type != ex.Symbol {
+					return ex.NewFatal("require: prefix must be a string or symbol")
+				}
+
+				prefix = args[1].String
+			}
+
+			exports, fatal := ir.require(args[0].String)
+			if fatal != nil {
+				return fatal
+			}
+
+			var names []*ex.Expr
+			for _, b := range exports {
+				ir.varsEnvironment.CurSymbols[prefix+b.name] = b.value
+				names = append(names, ex.NewSymbol(prefix+b.name))
+			}
+
+			return sliceToList(names)
+		},
+	}
+}
@@ -0,0 +1,116 @@
+package interpreter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	ex "github.com/batrSens/LispXS/expressions"
+	"github.com/batrSens/LispXS/parser"
+)
+
+// LibraryRoots are directories where files of load, import and require are searched when they aren't found
+// relative to the file being loaded. By default it is taken from LISPXS_PATH environment variable.
+var LibraryRoots = filepath.SplitList(os.Getenv("LISPXS_PATH"))
+
+// resolvePath returns absolute path of the file. Relative path is resolved against directory of the current
+// file (working directory at the top level) and then against library roots.
+func (ir *interpreter) resolvePath(path string) (string, error) {
+	base := ""
+	if n := len(ir.files); n > 0 {
+		base = filepath.Dir(ir.files[n-1])
+	}
+
+	return resolvePath(base, path)
+}
+
+func resolvePath(base, path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+
+	if base == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+
+		base = wd
+	}
+
+	for _, dir := range append([]string{base}, LibraryRoots...) {
+		res, err := filepath.Abs(filepath.Join(dir, path))
+		if err != nil {
+			return "", err
+		}
+
+		if _, err := os.Stat(res); err == nil {
+			return res, nil
+		}
+	}
+
+	return "", &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+}
+
+// readFile resolves the path and parses the file.
+func (ir *interpreter) readFile(name, path string) (string, *ex.Expr) {
+	abs, err := ir.resolvePath(path)
+	if err != nil {
+		return "", ex.NewFatal(name + ": " + err.Error())
+	}
+
+	file, err := ioutil.ReadFile(abs)
+	if err != nil {
+		return "", ex.NewFatal(name + ": " + err.Error())
+	}
+
+	program, err := parser.NewParser(string(file)).Parse()
+	if err != nil {
+		return "", ex.NewFatal(name + ": " + path + ": " + err.Error())
+	}
+
+	return abs, program
+}
+
+// evalFile calculates program of the file in the vars, the file is current while it is calculated.
+func (ir *interpreter) evalFile(path string, program *ex.Expr, vars *ex.Vars) *ex.Expr {
+	ir.files = append(ir.files, path)
+	res := ir.eval(program, vars)
+	ir.files = ir.files[:len(ir.files)-1]
+
+	return res
+}
+
+func init() {
+	// import calculates code by a nested run, so it can't be defined in the functions' declaration
+	functions["import"] = Func{
+		Doc: "(import path) - calculates expressions of file in current scope and returns list of their results.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 1 {
+				return ex.NewFatal("import: must be 1 argument")
+			}
+
+			if args[0].Type != ex.Symbol {
+				return ex.NewFatal("import: path must be a string or symbol")
+			}
+
+			path, program := ir.readFile("import", args[0].String)
+			if program.Type == ex.Fatal {
+				return program
+			}
+
+			var res []*ex.Expr
+			for cur := program; cur.Type == ex.Pair; cur = cur.Cdr() {
+				r := ir.evalFile(path, cur.Car().ToList(), ir.varsEnvironment)
+				if r.Type == ex.Fatal {
+					return r
+				}
+
+				res = append(res, r)
+			}
+
+			return sliceToList(res)
+		},
+	}
+
+}
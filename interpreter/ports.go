@@ -0,0 +1,338 @@
+package interpreter
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	ex "github.com/batrSens/LispXS/expressions"
+	"github.com/batrSens/LispXS/parser"
+)
+
+// openPort registers the stream, so it is closed by the owner of the interpreter.
+func (ir *interpreter) openPort(stream *ex.Stream) *ex.Expr {
+	ir.ports = append(ir.ports, stream)
+	return ex.NewPort(stream)
+}
+
+// closePort closes the stream and forgets it.
+func (ir *interpreter) closePort(stream *ex.Stream) error {
+	for i, p := range ir.ports {
+		if p == stream {
+			ir.ports = append(ir.ports[:i], ir.ports[i+1:]...)
+			break
+		}
+	}
+	delete(ir.readers, stream)
+
+	return stream.Close()
+}
+
+func (ir *interpreter) closePorts() {
+	for _, p := range ir.ports {
+		_ = p.Close()
+		delete(ir.readers, p)
+	}
+
+	ir.ports = nil
+}
+
+// reader returns the reader of expressions of the port. It is kept between calls, so the input that is read
+// ahead isn't lost.
+func (ir *interpreter) reader(stream *ex.Stream) (*parser.Reader, error) {
+	src, err := stream.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, ok := ir.readers[stream]
+	if !ok {
+		reader = parser.NewReader(src)
+		ir.readers[stream] = reader
+	}
+
+	return reader, nil
+}
+
+// portArg returns the port that is i-th argument or the default one if there isn't such argument.
+func portArg(name string, args []*ex.Expr, i int, def *ex.Expr) (*ex.Stream, *ex.Expr) {
+	if len(args) > i+1 {
+		return nil, ex.NewFatal(name + ": too many arguments")
+	}
+
+	port := def
+	if len(args) == i+1 {
+		port = args[i]
+	}
+
+	if port.Type != ex.Port {
+		return nil, ex.NewFatal(name + ": expected port, given " + port.ToString())
+	}
+
+	return port.Stream, nil
+}
+
+func pathArg(name string, args []*ex.Expr) (string, *ex.Expr) {
+	if len(args) != 1 {
+		return "", ex.NewFatal(name + ": must be 1 argument")
+	}
+
+	if args[0].Type != ex.Symbol {
+		return "", ex.NewFatal(name + ": argument must be a string or symbol, given " + args[0].ToString())
+	}
+
+	return args[0].String, nil
+}
+
+// prettyWidth is the width of lines written by pretty-print.
+const prettyWidth = 80
+
+// writeExpr writes the string representation of the first argument to the port given by the second one.
+func writeExpr(ir *interpreter, name string, args []*ex.Expr, str func(*ex.Expr) string) *ex.Expr {
+	if len(args) == 0 {
+		return ex.NewFatal(name + ": expected one expression")
+	}
+
+	stream, fatal := portArg(name, args, 1, ir.outputPort)
+	if fatal != nil {
+		return fatal
+	}
+
+	if err := stream.Write(str(args[0])); err != nil {
+		return ex.NewFatal(name + ": " + err.Error())
+	}
+
+	return args[0]
+}
+
+// readChar reads a character from the port; nil is returned at the end of input.
+func readChar(name string, args []*ex.Expr, def *ex.Expr, peek bool) *ex.Expr {
+	stream, fatal := portArg(name, args, 0, def)
+	if fatal != nil {
+		return fatal
+	}
+
+	reader, err := stream.Reader()
+	if err != nil {
+		return ex.NewFatal(name + ": " + err.Error())
+	}
+
+	r, _, err := reader.ReadRune()
+	if err == io.EOF {
+		return ex.NewNil()
+	} else if err != nil {
+		return ex.NewFatal(name + ": " + err.Error())
+	}
+
+	if peek {
+		_ = reader.UnreadRune()
+	}
+
+	return ex.NewSymbol(string(r))
+}
+
+func init() {
+	functions["open-input-file"] = Func{
+		Doc: "(open-input-file path) - port that reads from the file.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			path, fatal := pathArg("open-input-file", args)
+			if fatal != nil {
+				return fatal
+			}
+
+			file, err := os.Open(path)
+			if err != nil {
+				return ex.NewFatal("open-input-file: " + err.Error())
+			}
+
+			return ir.openPort(ex.NewInputStream(path, file, file))
+		},
+	}
+
+	functions["open-output-file"] = Func{
+		Doc: "(open-output-file path) - port that writes to the file, the file is truncated.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			path, fatal := pathArg("open-output-file", args)
+			if fatal != nil {
+				return fatal
+			}
+
+			file, err := os.Create(path)
+			if err != nil {
+				return ex.NewFatal("open-output-file: " + err.Error())
+			}
+
+			return ir.openPort(ex.NewOutputStream(path, file, file))
+		},
+	}
+
+	functions["open-input-string"] = Func{
+		Doc: "(open-input-string string) - port that reads from the string.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			str, fatal := pathArg("open-input-string", args)
+			if fatal != nil {
+				return fatal
+			}
+
+			return ir.openPort(ex.NewInputStream("string", strings.NewReader(str), nil))
+		},
+	}
+
+	functions["open-output-string"] = Func{
+		Doc: "(open-output-string) - port that collects written characters, see get-output-string.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 0 {
+				return ex.NewFatal("open-output-string: must be 0 arguments")
+			}
+
+			return ir.openPort(ex.NewStringStream())
+		},
+	}
+
+	functions["get-output-string"] = Func{
+		Doc: "(get-output-string port) - characters written to the port created by open-output-string.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 1 {
+				return ex.NewFatal("get-output-string: must be 1 argument")
+			}
+
+			stream, fatal := portArg("get-output-string", args, 0, nil)
+			if fatal != nil {
+				return fatal
+			}
+
+			str, err := stream.OutputString()
+			if err != nil {
+				return ex.NewFatal("get-output-string: " + err.Error())
+			}
+
+			return ex.NewSymbol(str)
+		},
+	}
+
+	functions["close-port"] = Func{
+		Doc: "(close-port port) - closes the port.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 1 {
+				return ex.NewFatal("close-port: must be 1 argument")
+			}
+
+			stream, fatal := portArg("close-port", args, 0, nil)
+			if fatal != nil {
+				return fatal
+			}
+
+			if err := ir.closePort(stream); err != nil {
+				return ex.NewFatal("close-port: " + err.Error())
+			}
+
+			return ex.NewT()
+		},
+	}
+
+	functions["port?"] = Func{
+		Doc: "(port? x) - T if x is a port.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 1 {
+				return ex.NewFatal("port?: must be 1 argument")
+			}
+
+			if args[0].Type == ex.Port {
+				return ex.NewT()
+			}
+
+			return ex.NewNil()
+		},
+	}
+
+	functions["current-input-port"] = Func{
+		Doc: "(current-input-port) - port of the input channel.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			return ir.inputPort
+		},
+	}
+
+	functions["current-output-port"] = Func{
+		Doc: "(current-output-port) - port of the output channel.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			return ir.outputPort
+		},
+	}
+
+	functions["current-error-port"] = Func{
+		Doc: "(current-error-port) - port of the error channel.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			return ir.errorPort
+		},
+	}
+
+	functions["read-line"] = Func{
+		Doc: "(read-line [port]) - next line of the port without line break or nil at the end of input.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			stream, fatal := portArg("read-line", args, 0, ir.inputPort)
+			if fatal != nil {
+				return fatal
+			}
+
+			reader, err := stream.Reader()
+			if err != nil {
+				return ex.NewFatal("read-line: " + err.Error())
+			}
+
+			line, err := reader.ReadString('\n')
+			if err == io.EOF && line == "" {
+				return ex.NewNil()
+			} else if err != nil && err != io.EOF {
+				return ex.NewFatal("read-line: " + err.Error())
+			}
+
+			return ex.NewSymbol(strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"))
+		},
+	}
+
+	functions["read-char"] = Func{
+		Doc: "(read-char [port]) - next character of the port or nil at the end of input.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			return readChar("read-char", args, ir.inputPort, false)
+		},
+	}
+
+	functions["peek-char"] = Func{
+		Doc: "(peek-char [port]) - next character of the port without reading it or nil at the end of input.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			return readChar("peek-char", args, ir.inputPort, true)
+		},
+	}
+
+	// call-with-port calls the closure, so it can't be defined in the functions' declaration
+	functions["call-with-port"] = Func{
+		Doc: "(call-with-port port f) - calls f with the port and closes the port, even if f throws an error.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 2 {
+				return ex.NewFatal("call-with-port: must be 2 arguments")
+			}
+
+			stream, fatal := portArg("call-with-port", args[:1], 0, nil)
+			if fatal != nil {
+				return fatal
+			}
+
+			if !isCallable(args[1]) {
+				return ex.NewFatal("call-with-port: second argument must be a function or closure, given " + args[1].ToString())
+			}
+
+			res := ir.call(args[1], args[:1])
+			if res.Type == ex.Fatal {
+				// the error of f is reported instead of the error of closing
+				_ = ir.closePort(stream)
+				return res
+			}
+
+			if err := ir.closePort(stream); err != nil {
+				return ex.NewFatal("call-with-port: " + err.Error())
+			}
+
+			return res
+		},
+	}
+}
@@ -0,0 +1,73 @@
+package interpreter
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+
+	ex "github.com/batrSens/LispXS/expressions"
+	"github.com/batrSens/LispXS/parser"
+)
+
+// InterruptTag is the tag of the error by which Interrupt stops the calculation.
+const InterruptTag = "interrupted"
+
+// Interpreter keeps variables, loaded modules and opened ports between calculated programs, it is used by REPLs.
+type Interpreter struct {
+	interpreter *interpreter
+
+	// loaded is the file calculated again by Reload
+	loaded string
+}
+
+// New returns the interpreter with calculated prelude.
+func New(stdout, stderr io.Writer, stdin io.Reader) (*Interpreter, error) {
+	ir := newInterpreter(ex.NewNil(), stdout, stderr, stdin)
+
+	if res := ir.run(); res.Type == ex.Fatal {
+		return nil, errors.New(res.String)
+	}
+
+	return &Interpreter{interpreter: ir}, nil
+}
+
+// Eval calculates the program, its top-level definitions are kept for the next programs. Interrupt called
+// before Eval stops it too, see ResetInterrupt.
+func (in *Interpreter) Eval(program *ex.Expr) *ex.Expr {
+	ir := in.interpreter
+	ir.control = program
+	ir.dataStack = nil
+	ir.callStack = nil
+	ir.argsNum = 0
+	ir.mod = nil
+	ir.traceDepth = 0
+
+	return ir.run()
+}
+
+// EvalString parses and calculates the program.
+func (in *Interpreter) EvalString(program string) (*ex.Expr, error) {
+	exprs, err := parser.NewParser(program).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return in.Eval(exprs), nil
+}
+
+// Interrupt stops the running calculation by the error with InterruptTag, which can't be caught by catch.
+// It can be called from another goroutine.
+func (in *Interpreter) Interrupt() {
+	atomic.StoreInt32(&in.interpreter.interrupted, 1)
+}
+
+// ResetInterrupt discards the interruption requested while nothing was calculated. It must be called before
+// the calculation is known as running to others, so their Interrupt isn't lost.
+func (in *Interpreter) ResetInterrupt() {
+	atomic.StoreInt32(&in.interpreter.interrupted, 0)
+}
+
+// Close closes ports opened by calculated programs.
+func (in *Interpreter) Close() {
+	in.interpreter.closePorts()
+}
@@ -0,0 +1,98 @@
+package interpreter
+
+import (
+	"io"
+	"io/ioutil"
+
+	ex "github.com/batrSens/LispXS/expressions"
+	"github.com/batrSens/LispXS/parser"
+)
+
+// ExitTag is the tag of the error by which exit stops the program, its value is the exit code.
+const ExitTag = "exit"
+
+// ExecuteFile calculates the script with the arguments, paths loaded by it are resolved against its directory.
+// command-line returns the path followed by the arguments.
+func ExecuteFile(path string, args []string, ioout, ioerr io.Writer, ioin io.Reader) (*ex.Expr, error) {
+	commandLine := append([]string{path}, args...)
+
+	path, err := resolvePath("", path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	exprs, err := parser.NewParser(string(file)).Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	interpreter := newInterpreter(exprs, ioout, ioerr, ioin)
+	interpreter.files = []string{path}
+	interpreter.commandLine = commandLine
+	defer interpreter.closePorts()
+
+	return interpreter.run(), nil
+}
+
+// ExitCode returns the exit code of the process for the result of the program: 0 for a value, the code given
+// to exit or 1 for other uncaught errors and codes which aren't valid exit statuses.
+func ExitCode(res *ex.Expr) int {
+	if res.Type != ex.Fatal {
+		return 0
+	}
+
+	if res.IsExit() && res.Res.Type == ex.Number && validExitCode(res.Res.Number) {
+		return int(res.Res.Number)
+	}
+
+	return 1
+}
+
+// validExitCode reports whether the number is an exit status of the process, statuses are bytes.
+func validExitCode(code float64) bool {
+	return code == float64(int(code)) && code >= 0 && code <= 255
+}
+
+func init() {
+	functions["exit"] = Func{
+		Doc: "(exit [code]) - stops the program with the exit code, 0 by default. It can't be caught by catch.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) > 1 {
+				return ex.NewFatal("exit: must be 0 or 1 argument")
+			}
+
+			code := ex.NewNumber(0)
+			if len(args) == 1 {
+				if args[0].Type != ex.Number || !validExitCode(args[0].Number) {
+					return ex.NewFatal("exit: code must be an integer from 0 to 255, given " + args[0].ToString())
+				}
+
+				code = args[0]
+			}
+
+			ir.stopping = true
+			return ex.NewExit(ExitTag, code)
+		},
+	}
+
+	functions["command-line"] = Func{
+		Doc: "(command-line) - list of the script path and its arguments, nil if the program isn't a script.",
+		F: func(ir *interpreter, args []*ex.Expr) *ex.Expr {
+			if len(args) != 0 {
+				return ex.NewFatal("command-line: must be 0 arguments")
+			}
+
+			var res []*ex.Expr
+			for _, arg := range ir.commandLine {
+				res = append(res, ex.NewSymbol(arg))
+			}
+
+			return sliceToList(res)
+		},
+	}
+}
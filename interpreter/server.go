@@ -0,0 +1,307 @@
+package interpreter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	ex "github.com/batrSens/LispXS/expressions"
+)
+
+// Requests and responses of the network REPL are sent as frames: 4 bytes of big-endian length followed by
+// the JSON object.
+const (
+	// OpEval calculates the program or runs the REPL command given in Code.
+	OpEval = "eval"
+	// OpInterrupt interrupts the calculation of Session, the session of the request by default.
+	OpInterrupt = "interrupt"
+)
+
+// maxFrame limits the length of received frames.
+const maxFrame = 64 << 20
+
+// maxQueued limits the number of eval requests of the session waiting for the calculation, the requests above
+// it are answered by an error.
+const maxQueued = 16
+
+// errDisconnected is returned by calculate for requests of the disconnected session, they are dropped.
+var errDisconnected = errors.New("session is disconnected")
+
+// Request is the frame sent by the REPL client.
+type Request struct {
+	ID      int64  `json:"id"`
+	Op      string `json:"op"`
+	Code    string `json:"code,omitempty"`
+	Session int64  `json:"session,omitempty"`
+}
+
+// Response is the frame sent by the server for the request with the same ID. The first frame of the connection
+// has ID 0 and the number of the session. Result and Fatal are written as by `-o json`, Error is set if the
+// request isn't done.
+type Response struct {
+	ID      int64      `json:"id"`
+	Session int64      `json:"session,omitempty"`
+	Result  *string    `json:"result"`
+	Stdout  string     `json:"stdout"`
+	Stderr  string     `json:"stderr"`
+	Fatal   *jsonFatal `json:"fatal"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// WriteFrame writes v as a frame.
+func WriteFrame(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	_, err = w.Write(append(frame, data...))
+	return err
+}
+
+// ReadFrame reads the frame to v.
+func ReadFrame(r io.Reader, v interface{}) error {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+
+	length := binary.BigEndian.Uint32(header[:])
+	if length > maxFrame {
+		return fmt.Errorf("frame of %d bytes is too long", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// streams are the standard ports of the interpreter and writers of stack traces and traced calls.
+type streams struct {
+	stdout, stderr                   io.Writer
+	inputPort, outputPort, errorPort *ex.Expr
+}
+
+// setStreams replaces streams of the interpreter and returns the previous ones.
+func (ir *interpreter) setStreams(s streams) streams {
+	old := streams{ir.stdout, ir.stderr, ir.inputPort, ir.outputPort, ir.errorPort}
+	ir.stdout, ir.stderr, ir.inputPort, ir.outputPort, ir.errorPort =
+		s.stdout, s.stderr, s.inputPort, s.outputPort, s.errorPort
+
+	return old
+}
+
+type replServer struct {
+	interp *Interpreter
+
+	// calculating serializes calculations of sessions, running is the session being calculated
+	calculating sync.Mutex
+	mu          sync.Mutex
+	running     int64
+}
+
+type session struct {
+	id   int64
+	conn net.Conn
+
+	writing        sync.Mutex
+	stdout, stderr bytes.Buffer
+	streams        streams
+
+	// disconnected is guarded by mu of the server
+	disconnected bool
+}
+
+// ServeREPL accepts connections of REPL clients and serves every connection as a session. Sessions are
+// calculated by the interpreter one at a time, output of the calculation is sent to its session. The interpreter
+// mustn't be used by others while it is served. ServeREPL returns the error of Accept.
+func ServeREPL(l net.Listener, interp *Interpreter) error {
+	s := &replServer{interp: interp}
+
+	for id := int64(1); ; id++ {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.serve(conn, id)
+	}
+}
+
+func (s *replServer) serve(conn net.Conn, id int64) {
+	defer conn.Close()
+
+	ses := &session{id: id, conn: conn}
+	ses.streams = streams{
+		stdout:     &ses.stdout,
+		stderr:     &ses.stderr,
+		inputPort:  ex.NewPort(ex.NewInputStream("stdin", strings.NewReader(""), nil)),
+		outputPort: ex.NewPort(ex.NewOutputStream("stdout", &ses.stdout, nil)),
+		errorPort:  ex.NewPort(ex.NewOutputStream("stderr", &ses.stderr, nil)),
+	}
+
+	if ses.send(&Response{Session: id}) != nil {
+		return
+	}
+
+	// requests are read while the session is calculated, so it can be interrupted
+	evals := make(chan *Request, maxQueued)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for req := range evals {
+			if !s.eval(ses, req) {
+				conn.Close()
+			}
+		}
+	}()
+
+	for {
+		req := &Request{}
+		if err := ReadFrame(conn, req); err != nil {
+			break
+		}
+
+		switch req.Op {
+		case OpEval:
+			// the loop doesn't wait for the queue, so interruptions are read
+			select {
+			case evals <- req:
+			default:
+				_ = ses.send(&Response{ID: req.ID, Error: "too many queued requests"})
+			}
+		case OpInterrupt:
+			target := req.Session
+			if target == 0 {
+				target = id
+			}
+
+			res := &Response{ID: req.ID}
+			if err := s.interrupt(target); err != nil {
+				res.Error = err.Error()
+			}
+			_ = ses.send(res)
+		default:
+			_ = ses.send(&Response{ID: req.ID, Error: "unknown operation " + req.Op})
+		}
+	}
+
+	s.disconnect(ses)
+	close(evals)
+	<-done
+}
+
+// eval calculates the request and sends the response. It returns false if the session is finished by :quit.
+func (s *replServer) eval(ses *session, req *Request) bool {
+	res, err := s.calculate(ses, req.Code)
+	if err == errDisconnected {
+		return false
+	}
+
+	response := &Response{ID: req.ID, Stdout: ses.stdout.String(), Stderr: ses.stderr.String()}
+	ses.stdout.Reset()
+	ses.stderr.Reset()
+
+	if err != nil && err != ErrQuit {
+		response.Error = err.Error()
+	} else if res != nil {
+		response.Result, response.Fatal = resultJSON(res)
+	}
+
+	return ses.send(response) == nil && err != ErrQuit
+}
+
+func (s *replServer) calculate(ses *session, code string) (res *ex.Expr, err error) {
+	s.calculating.Lock()
+	defer s.calculating.Unlock()
+
+	// interruptions requested before the session is running are discarded, later ones stop it
+	s.interp.ResetInterrupt()
+	if !s.start(ses) {
+		return nil, errDisconnected
+	}
+	defer s.setRunning(0)
+
+	ir := s.interp.interpreter
+	old := ir.setStreams(ses.streams)
+	defer ir.setStreams(old)
+
+	defer func() {
+		if r := recover(); r != nil {
+			ir.depth = 0
+			res, err = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	// results of commands are printed by them, only errors are sent
+	if IsCommand(code) {
+		res, err := s.interp.Command(code, &ses.stdout)
+		if res != nil && res.Type != ex.Fatal {
+			res = nil
+		}
+
+		return res, err
+	}
+
+	return s.interp.EvalString(code)
+}
+
+// start makes the session running unless it is disconnected.
+func (s *replServer) start(ses *session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ses.disconnected {
+		return false
+	}
+
+	s.running = ses.id
+	return true
+}
+
+// disconnect interrupts the calculation of the session, requests of the session left in the queue are dropped.
+func (s *replServer) disconnect(ses *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ses.disconnected = true
+	if s.running == ses.id {
+		s.interp.Interrupt()
+	}
+}
+
+func (s *replServer) setRunning(id int64) {
+	s.mu.Lock()
+	s.running = id
+	s.mu.Unlock()
+}
+
+func (s *replServer) interrupt(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running != id {
+		return fmt.Errorf("session %d isn't calculating", id)
+	}
+
+	s.interp.Interrupt()
+	return nil
+}
+
+func (ses *session) send(res *Response) error {
+	ses.writing.Lock()
+	defer ses.writing.Unlock()
+
+	return WriteFrame(ses.conn, res)
+}
@@ -1,8 +1,12 @@
 package lexer
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"strconv"
+	"strings"
 	"unicode"
 )
 
@@ -14,6 +18,7 @@ const (
 	TagQuote
 	TagComma
 	TagEOF
+	TagComment
 )
 
 type Coords struct {
@@ -28,13 +33,21 @@ func NewCoords() Coords {
 	}
 }
 
+// Token has coordinates of its end and of its start, Text is the source of the token.
 type Token struct {
 	Coords Coords
+	Start  Coords
 	Tag    int
 	String string
+	Text   string
 	Number float64
 }
 
+// IsString reports whether the token is a string literal: a symbol written between double quotes.
+func (t *Token) IsString() bool {
+	return t.Tag == TagSymbol && strings.HasPrefix(t.Text, "\"")
+}
+
 type LexError struct {
 	Coords  Coords
 	Message string
@@ -47,6 +60,15 @@ func (le LexError) Error() string {
 type Lexer struct {
 	text   []rune
 	coords Coords
+
+	// src is the source of characters of the stream lexer, text holds characters read from it starting
+	// at cursor base.
+	src    io.RuneScanner
+	srcErr error
+	srcEOF bool
+	base   int
+
+	comments bool
 }
 
 func NewLexer(text string) *Lexer {
@@ -58,7 +80,26 @@ func NewLexer(text string) *Lexer {
 	}
 }
 
+// NewCommentLexer returns the lexer that returns comments as tokens too.
+func NewCommentLexer(text string) *Lexer {
+	l := NewLexer(text)
+	l.comments = true
+	return l
+}
+
+// NewStreamLexer returns the lexer that reads characters from src only when they are needed.
+func NewStreamLexer(src io.RuneScanner) *Lexer {
+	return &Lexer{
+		coords: NewCoords(),
+		src:    src,
+	}
+}
+
 func (l *Lexer) NextToken() (*Token, error) {
+	if l.srcErr != nil {
+		return nil, l.srcErr
+	}
+
 	if l.eof() {
 		return l.token(TagEOF), nil
 	}
@@ -71,25 +112,64 @@ func (l *Lexer) NextToken() (*Token, error) {
 		return l.token(TagEOF), nil
 	}
 
+	start := l.coords
+	if l.getCurrentChar() == ';' || l.shebang() {
+		for l.getCurrentChar() != '\n' {
+			l.moveCursor()
+		}
+
+		if !l.comments {
+			return l.NextToken()
+		}
+
+		tok := l.tokenString(TagComment, strings.TrimRightFunc(l.textFrom(start), unicode.IsSpace))
+		tok.Start = start
+		return tok, nil
+	}
+
+	tok, err := l.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	tok.Start = start
+	tok.Text = l.textFrom(start)
+	return tok, nil
+}
+
+// shebang reports whether the text starts with the line "#!/path/to/interpreter" of a script, the line is
+// skipped like a comment.
+func (l *Lexer) shebang() bool {
+	if l.src != nil || l.coords.Cursor != 0 || len(l.text) < 3 {
+		return false
+	}
+
+	prefix := string(l.text[:3])
+	return prefix == "#!/" || prefix == "#! "
+}
+
+func (l *Lexer) scan() (*Token, error) {
 	var res *Token
 
 	switch l.getCurrentChar() {
-	case ';':
+	case '|':
 		{
-			for l.getCurrentChar() != '\n' {
-				l.moveCursor()
+			sym, err := l.parseStrWithBorder('|')
+			if err != nil {
+				return nil, err
 			}
 
-			return l.NextToken()
+			return l.tokenString(TagSymbol, sym), nil
 		}
-	case '|':
+	case '"':
 		{
-			sym, err := l.parseStrWithBorder('|')
+			str, err := l.parseStrWithBorder('"')
 			if err != nil {
 				return nil, err
 			}
 
-			return l.tokenString(TagSymbol, sym), nil
+			// string is a symbol for the lexer, the parser tells it by the text, see IsString
+			return l.tokenString(TagSymbol, str), nil
 		}
 	case '(':
 		res = l.token(TagLPar)
@@ -203,6 +283,12 @@ func (l *Lexer) parseSymbolOrNumber() (*Token, error) {
 	}
 
 	if l.isWSOrPar() {
+		// the text is parsed again to get the nearest float, the value out of range is rounded to infinity or zero
+		exact, err := strconv.ParseFloat(string(l.text[start-l.base:l.coords.Cursor-l.base]), 64)
+		if err == nil || errors.Is(err, strconv.ErrRange) {
+			res = exact
+		}
+
 		return l.tokenNumber(TagNumber, res), nil
 	}
 
@@ -214,7 +300,7 @@ func (l *Lexer) parseSymbol(start int) (*Token, error) {
 		l.moveCursor()
 	}
 
-	res := string(l.text[start:l.coords.Cursor])
+	res := string(l.text[start-l.base : l.coords.Cursor-l.base])
 	return l.tokenString(TagSymbol, res), nil
 }
 
@@ -236,16 +322,101 @@ func (l *Lexer) getNumber() (int, int) {
 	return res, length
 }
 
+// SkipLine skips spaces and comments up to the end of the line. It returns true and moves to the next line if
+// the rest of the line is empty.
+func (l *Lexer) SkipLine() bool {
+	for !l.eof() {
+		c := l.getCurrentChar()
+		switch {
+		case c == '\n':
+			l.moveCursor()
+			return true
+		case c == ';':
+			for l.getCurrentChar() != '\n' {
+				l.moveCursor()
+			}
+		case unicode.IsSpace(c):
+			l.moveCursor()
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// SkipRest skips the rest of the line.
+func (l *Lexer) SkipRest() {
+	for !l.eof() {
+		c := l.getCurrentChar()
+		l.moveCursor()
+		if c == '\n' {
+			return
+		}
+	}
+}
+
+// Finished reports whether all characters of the text or the source are read.
+func (l *Lexer) Finished() bool {
+	return len(l.text) <= l.coords.Cursor-l.base && (l.src == nil || l.srcEOF)
+}
+
+// Release returns the character read ahead to the source of the stream lexer and forgets read characters,
+// so the source can be read by others between tokens.
+func (l *Lexer) Release() error {
+	if l.src == nil {
+		return nil
+	}
+
+	if len(l.text) > l.coords.Cursor-l.base && !l.srcEOF {
+		if err := l.src.UnreadRune(); err != nil {
+			return err
+		}
+
+		l.text = l.text[:len(l.text)-1]
+	}
+
+	l.text = l.text[l.coords.Cursor-l.base:]
+	l.base = l.coords.Cursor
+	return nil
+}
+
+// fill reads the current character from the source of the stream lexer. The end of the source is
+// followed by a line break, as the end of the text.
+func (l *Lexer) fill() {
+	if l.src == nil || l.srcEOF || l.coords.Cursor-l.base < len(l.text) {
+		return
+	}
+
+	c, _, err := l.src.ReadRune()
+	if err != nil {
+		if err != io.EOF {
+			l.srcErr = err
+		}
+
+		l.srcEOF = true
+		c = '\n'
+	}
+
+	l.text = append(l.text, c)
+}
+
+func (l *Lexer) textFrom(start Coords) string {
+	return string(l.text[start.Cursor-l.base : l.coords.Cursor-l.base])
+}
+
 func (l *Lexer) getCurrentChar() rune {
-	return l.text[l.coords.Cursor]
+	l.fill()
+	return l.text[l.coords.Cursor-l.base]
 }
 
 func (l *Lexer) eof() bool {
-	return len(l.text) <= l.coords.Cursor
+	l.fill()
+	return len(l.text) <= l.coords.Cursor-l.base
 }
 
 func (l *Lexer) moveCursor() {
-	if len(l.text) <= l.coords.Cursor {
+	if l.eof() {
 		panic("eof")
 	}
 
@@ -55,3 +55,27 @@ func TestLexer(t *testing.T) {
 	tok, _ = lx.NextToken()
 	assert.Equal(t, tok.Tag, TagEOF)
 }
+
+func TestShebang(t *testing.T) {
+	lx := NewLexer("#!/usr/bin/env lispxs\n#!optional")
+	tok, _ := lx.NextToken()
+	assert.Equal(t, tok.Tag, TagSymbol)
+	assert.Equal(t, tok.String, "#!optional")
+
+	lx = NewCommentLexer("#!/usr/bin/env lispxs\n")
+	tok, _ = lx.NextToken()
+	assert.Equal(t, tok.Tag, TagComment)
+	assert.Equal(t, tok.String, "#!/usr/bin/env lispxs")
+}
+
+func TestString(t *testing.T) {
+	lx := NewLexer(`"a \"b\"" |c|`)
+	tok, _ := lx.NextToken()
+	assert.Equal(t, tok.Tag, TagSymbol)
+	assert.Equal(t, tok.String, `a "b"`)
+	assert.Equal(t, tok.IsString(), true)
+
+	tok, _ = lx.NextToken()
+	assert.Equal(t, tok.Tag, TagSymbol)
+	assert.Equal(t, tok.IsString(), false)
+}
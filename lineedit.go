@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// maxHistory is the number of lines kept in the history.
+const maxHistory = 1000
+
+// errInterrupted is returned by readLine when the line is canceled by Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+// lineEditor reads lines from the terminal with cursor movement, editing keys and the history. If the input
+// isn't a terminal, lines are read as they are.
+type lineEditor struct {
+	in  *bufio.Reader
+	out io.Writer
+	fd  int
+
+	history     []string
+	historyPath string
+
+	// complete returns the word before the cursor and its completions
+	complete func(head string) (string, []string)
+}
+
+func newLineEditor(in *bufio.Reader, out io.Writer, fd int, historyPath string) *lineEditor {
+	e := &lineEditor{in: in, out: out, fd: fd, historyPath: historyPath}
+
+	if historyPath != "" {
+		if data, err := ioutil.ReadFile(historyPath); err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				if line != "" {
+					e.history = append(e.history, unescapeHistory(line))
+				}
+			}
+		}
+	}
+
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+
+	return e
+}
+
+// historyFile returns the path of the history file: $LISPXS_HISTORY or ~/.lispxs_history.
+func historyFile() string {
+	if path := os.Getenv("LISPXS_HISTORY"); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return home + string(os.PathSeparator) + ".lispxs_history"
+}
+
+// addHistory adds the entry to the history as it is written and appends it to the history file.
+func (e *lineEditor) addHistory(entry string) {
+	entry = strings.TrimRight(entry, "\r\n")
+	if strings.TrimSpace(entry) == "" || len(e.history) > 0 && e.history[len(e.history)-1] == entry {
+		return
+	}
+
+	e.history = append(e.history, entry)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+
+	if e.historyPath == "" {
+		return
+	}
+
+	file, err := os.OpenFile(e.historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	_, _ = fmt.Fprintln(file, escapeHistory(entry))
+}
+
+// escapeHistory escapes line breaks and backslashes of the entry, so it's written on one line of the history file.
+func escapeHistory(entry string) string {
+	return strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r").Replace(entry)
+}
+
+// unescapeHistory restores the entry written by escapeHistory.
+func unescapeHistory(line string) string {
+	var res strings.Builder
+	for i := 0; i < len(line); i++ {
+		if line[i] != '\\' || i+1 == len(line) {
+			res.WriteByte(line[i])
+			continue
+		}
+
+		i++
+		switch line[i] {
+		case 'n':
+			res.WriteByte('\n')
+		case 'r':
+			res.WriteByte('\r')
+		default:
+			res.WriteByte(line[i])
+		}
+	}
+
+	return res.String()
+}
+
+// readLine reads the line after the prompt. io.EOF is returned by Ctrl-D on the empty line and
+// errInterrupted by Ctrl-C.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		line, err := e.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	defer restore()
+
+	l := &editedLine{editor: e, prompt: prompt, hist: len(e.history)}
+	l.refresh()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			e.write("\r\n")
+			return string(l.buf), nil
+		case 3: // Ctrl-C
+			e.write("^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(l.buf) == 0 {
+				e.write("\r\n")
+				return "", io.EOF
+			}
+			l.delete(l.pos, l.pos+1)
+		case 127, 8: // Backspace
+			l.delete(l.pos-1, l.pos)
+		case 1: // Ctrl-A
+			l.pos = 0
+		case 5: // Ctrl-E
+			l.pos = len(l.buf)
+		case 2: // Ctrl-B
+			l.move(-1)
+		case 6: // Ctrl-F
+			l.move(1)
+		case 11: // Ctrl-K
+			l.delete(l.pos, len(l.buf))
+		case 21: // Ctrl-U
+			l.delete(0, l.pos)
+		case 23: // Ctrl-W
+			start := l.pos
+			for start > 0 && unicode.IsSpace(l.buf[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(l.buf[start-1]) {
+				start--
+			}
+			l.delete(start, l.pos)
+		case 12: // Ctrl-L
+			e.write("\x1b[H\x1b[2J")
+		case 16: // Ctrl-P
+			l.browse(-1)
+		case 14: // Ctrl-N
+			l.browse(1)
+		case 27:
+			l.escape()
+		case '\t':
+			l.complete()
+		default:
+			if unicode.IsPrint(r) {
+				l.insert([]rune{r})
+			}
+		}
+
+		l.refresh()
+	}
+}
+
+func (e *lineEditor) write(str string) {
+	_, _ = io.WriteString(e.out, str)
+}
+
+// editedLine is the state of the line being read.
+type editedLine struct {
+	editor *lineEditor
+	prompt string
+	buf    []rune
+	pos    int
+
+	// hist is the index of the history entry being shown, saved is the line that was edited before browsing
+	hist  int
+	saved []rune
+}
+
+// escape handles escape sequences of arrows, Home, End and Delete keys.
+func (l *editedLine) escape() {
+	in := l.editor.in
+	if b, err := in.ReadByte(); err != nil || b != '[' && b != 'O' {
+		return
+	}
+
+	code, err := in.ReadByte()
+	if err != nil {
+		return
+	}
+
+	if code >= '0' && code <= '9' {
+		if b, err := in.ReadByte(); err != nil || b != '~' {
+			return
+		}
+	}
+
+	switch code {
+	case 'A':
+		l.browse(-1)
+	case 'B':
+		l.browse(1)
+	case 'C':
+		l.move(1)
+	case 'D':
+		l.move(-1)
+	case 'H', '1', '7':
+		l.pos = 0
+	case 'F', '4', '8':
+		l.pos = len(l.buf)
+	case '3':
+		l.delete(l.pos, l.pos+1)
+	}
+}
+
+// complete replaces the word before the cursor with the common prefix of its completions, several completions
+// are listed under the line. Tab after a blank inserts the indentation.
+func (l *editedLine) complete() {
+	var word string
+	var candidates []string
+	if l.editor.complete != nil {
+		word, candidates = l.editor.complete(string(l.buf[:l.pos]))
+	}
+
+	if len(candidates) == 0 {
+		if word == "" {
+			l.insert([]rune("  "))
+		}
+		return
+	}
+
+	// the prefix is shortened by characters, so a multibyte character isn't cut
+	prefix := []rune(candidates[0])
+	for _, c := range candidates[1:] {
+		prefix = commonPrefix(prefix, []rune(c))
+	}
+
+	if w := []rune(word); len(prefix) > len(w) {
+		l.insert(prefix[len(w):])
+		return
+	}
+
+	if len(candidates) > 1 {
+		l.editor.write("\r\n" + strings.Join(candidates, "  ") + "\r\n")
+	}
+}
+
+// commonPrefix returns the longest common prefix of a and b.
+func commonPrefix(a, b []rune) []rune {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+
+	return a[:n]
+}
+
+func (l *editedLine) insert(runes []rune) {
+	buf := append(append(append([]rune{}, l.buf[:l.pos]...), runes...), l.buf[l.pos:]...)
+	l.buf = buf
+	l.pos += len(runes)
+}
+
+func (l *editedLine) delete(from, to int) {
+	if from < 0 || to > len(l.buf) || from >= to {
+		return
+	}
+
+	l.buf = append(l.buf[:from], l.buf[to:]...)
+	l.pos = from
+}
+
+func (l *editedLine) move(n int) {
+	if pos := l.pos + n; pos >= 0 && pos <= len(l.buf) {
+		l.pos = pos
+	}
+}
+
+// browse shows the previous (-1) or the next (1) history entry.
+func (l *editedLine) browse(n int) {
+	history := l.editor.history
+	hist := l.hist + n
+	if hist < 0 || hist > len(history) {
+		return
+	}
+
+	if l.hist == len(history) {
+		l.saved = l.buf
+	}
+
+	l.hist = hist
+	if hist == len(history) {
+		l.buf = l.saved
+	} else {
+		l.buf = []rune(history[hist])
+	}
+	l.pos = len(l.buf)
+}
+
+// refresh redraws the line and puts the cursor to its position. Line breaks of history entries are shown
+// as ↵, so the entry is edited on one line.
+func (l *editedLine) refresh() {
+	res := "\r" + l.prompt + strings.Replace(string(l.buf), "\n", "↵", -1) + "\x1b[K"
+	if back := len(l.buf) - l.pos; back > 0 {
+		res += fmt.Sprintf("\x1b[%dD", back)
+	}
+
+	l.editor.write(res)
+}
@@ -2,75 +2,133 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 
+	ex "github.com/batrSens/LispXS/expressions"
 	"github.com/batrSens/LispXS/interpreter"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fmt" {
+		os.Exit(fmtCommand(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "connect" {
+		os.Exit(connectCommand(os.Args[2:]))
+	}
+
 	newlines := flag.Bool("n", false, "waiting for double newline (\"\\n\\n\")")
 	eof := flag.Bool("e", false, "waiting for EOF")
 	_ = flag.Bool("r", false, "REPL mode (default)")
+	command := flag.String("c", "", "calculate the program given by the argument")
+	output := flag.String("o", "text", "output format: text or json")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lispxs [-n | -e | -r | -c program] [-o text|json] [script [arg ...]]\n       lispxs fmt [-l] [-d] [path ...]\n       lispxs connect address")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	// -c may be given an empty program, it is calculated instead of starting REPL
+	commandGiven := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "c" {
+			commandGiven = true
+		}
+	})
+
+	if *output != "text" && *output != "json" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	jsonOut := *output == "json"
+
+	if flag.NArg() > 0 {
+		os.Exit(run(jsonOut, nil, func(stdout, stderr io.Writer) (*ex.Expr, error) {
+			return interpreter.ExecuteFile(flag.Arg(0), flag.Args()[1:], stdout, stderr, os.Stdin)
+		}))
+	}
+
 	var prog string
 	var err error
 	reader := bufio.NewReader(os.Stdin)
 
-	if *newlines {
+	// the result of -c is written to be read by other programs, the result of -n and -e is written after '>'
+	printResult := func(res *ex.Expr) {
+		fmt.Println(">", res.ToString())
+	}
+
+	if commandGiven {
+		prog = *command
+		printResult = func(res *ex.Expr) {
+			// the error of a fatal result is already reported to stderr
+			if res.Type != ex.Fatal {
+				fmt.Println(res.ReadableString())
+			}
+		}
+	} else if *newlines {
 		line := "  "
-		var err error
 
 		for len(line) != 1 {
 			prog += line
 
 			line, err = reader.ReadString('\n')
 			if err != nil {
-				panic(err)
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
 			}
 		}
 	} else if *eof {
 		prog, err = reader.ReadString(0)
 		if err != nil && err != io.EOF {
-			panic(err)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	} else {
+		os.Exit(repl())
+	}
+
+	os.Exit(run(jsonOut, printResult, func(stdout, stderr io.Writer) (*ex.Expr, error) {
+		return interpreter.ExecuteTo(prog, stdout, stderr, os.Stdin)
+	}))
+}
+
+// run calculates the program and reports its result, it returns the exit code of the process. In json mode
+// the output of the program is collected and written with the result as a JSON object.
+func run(jsonOut bool, printResult func(*ex.Expr), execute func(stdout, stderr io.Writer) (*ex.Expr, error)) int {
+	if !jsonOut {
+		res, err := execute(os.Stdout, os.Stderr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
 		}
+
+		if printResult != nil {
+			printResult(res)
+		}
+		return interpreter.ExitCode(res)
+	}
+
+	var stdout, stderr bytes.Buffer
+	res, err := execute(&stdout, &stderr)
+
+	code := 2
+	if err != nil {
+		res = ex.NewFatal(err.Error())
 	} else {
-		fmt.Fprintln(os.Stderr, "LispXS v0.2.5")
-		_, _ = interpreter.ExecuteStdout(`
-            (define repl nil)
-            ((lambda ()
-              (define define define) (define lambda lambda) (define defmacro defmacro)
-              (define if if) (define cons cons) (define car car) (define cdr cdr) (define nil nil)
-              (define write write) (define begin begin) (define eval eval) (define read read)
-              (define catch catch)
-              (define list (lambda args args))
-              (defmacro map (f1 ,args1)
-                (define helper (lambda (f args)
-                  (if args
-                    (cons (list f (list quote (car args))) (helper f (cdr args))))))
-                (cons list (helper f1 args1)))
-              (define writeln (lambda (sym) (write sym) (write '|\n|) sym))
-              (defmacro repl1 ()
-                (list begin 
-                  (list write ''|> |) 
-                  (list map writeln 
-                    (list catch 
-                      (list map eval (list read)) 
-                      '(default (list (+ '|ERROR, | error_description))))) 
-                  (list repl1)))
-              (set! repl repl1)))
-            (repl)`)
-		return
+		code = interpreter.ExitCode(res)
 	}
 
-	res, err := interpreter.ExecuteStdout(prog)
+	data, err := json.Marshal(&interpreter.Output{Stdout: stdout.String(), Stderr: stderr.String(), Output: res})
 	if err != nil {
-		panic(err)
-		return
+		fmt.Fprintln(os.Stderr, err)
+		return 2
 	}
 
-	fmt.Println(">", res.ToString())
+	fmt.Println(string(data))
+	return code
 }
@@ -29,11 +29,12 @@ func (pe *ParseError) Error() string {
 // PROGRAM ::= INNER eof
 // LIST    ::= ( INNER )
 // INNER   ::= ELEM INNER | .
-// ELEM    ::= ' ELEM | , ELEM | number | symbol | LIST
+// ELEM    ::= ' ELEM | , ELEM | number | symbol | string | LIST
 
+// Parser reads expressions from tokens of the lexer. A token is read only when it's needed, so Reader can
+// use the parser without reading ahead of the finished expression.
 type Parser struct {
-	curToken *lexer.Token
-	lexer    *lexer.Lexer
+	lexer *lexer.Lexer
 }
 
 func NewParser(text string) *Parser {
@@ -44,136 +45,121 @@ func NewParser(text string) *Parser {
 
 // PROGRAM ::= INNER eof
 func (p *Parser) Parse() (*ex.Expr, error) {
-	err := p.nextToken()
-	if err != nil {
-		return nil, err
-	}
-
-	prog, err := p.parseInner()
-	if err != nil {
-		return nil, err
-	}
-
-	err = p.expect(lexer.TagEOF)
-	if err != nil {
-		return nil, err
-	}
-
-	return prog, nil
-}
-
-// LIST ::= ( INNER )
-func (p *Parser) parseList() (*ex.Expr, error) {
-	err := p.expect(lexer.TagLPar)
-	if err != nil {
-		return nil, err
-	}
-
-	res, err := p.parseInner()
-	if err != nil {
-		return nil, err
-	}
-
-	err = p.expect(lexer.TagRPar)
-	if err != nil {
-		return nil, err
-	}
-
-	return res, nil
-}
-
-// INNER ::= ELEM INNER | .
-func (p *Parser) parseInner() (*ex.Expr, error) {
-	if p.curToken.Tag != lexer.TagRPar && p.curToken.Tag != lexer.TagEOF {
-		elem, err := p.parseElem()
+	var exprs []*ex.Expr
+	for {
+		tok, err := p.lexer.NextToken()
 		if err != nil {
 			return nil, err
 		}
 
-		list, err := p.parseInner()
-		if err != nil {
-			return nil, err
+		switch tok.Tag {
+		case lexer.TagEOF:
+			return list(exprs)
+		case lexer.TagRPar:
+			return nil, NewParseErr(tok.Tag, lexer.TagEOF, "unexpected", tok.Coords)
 		}
 
-		res := elem.Cons(list)
-		if res.Type == ex.Fatal {
-			return nil, ex.NewExprError(res.String)
+		expr, err := p.parseElem(tok)
+		if err != nil {
+			return nil, err
 		}
 
-		return res, nil
+		exprs = append(exprs, expr)
 	}
-
-	return ex.NewNil(), nil
 }
 
-// ELEM ::= ' ELEM | , ELEM | number | symbol | LIST
-func (p *Parser) parseElem() (*ex.Expr, error) {
-	var res *ex.Expr
-
-	switch p.curToken.Tag {
-	case lexer.TagQuote:
-		err := p.expect(lexer.TagQuote)
+// LIST ::= ( INNER ), the left parenthesis is already read.
+func (p *Parser) parseList() (*ex.Expr, error) {
+	var items []*ex.Expr
+	for {
+		tok, err := p.lexer.NextToken()
 		if err != nil {
 			return nil, err
 		}
 
-		expr, err := p.parseElem()
+		switch tok.Tag {
+		case lexer.TagRPar:
+			return list(items)
+		case lexer.TagEOF:
+			return nil, NewParseErr(tok.Tag, lexer.TagRPar, "unexpected", tok.Coords)
+		}
+
+		item, err := p.parseElem(tok)
 		if err != nil {
 			return nil, err
 		}
 
-		res := ex.NewFunction("quote").Cons(expr.ToList())
-		if res.Type == ex.Fatal {
-			return nil, ex.NewExprError(res.String)
-		}
+		items = append(items, item)
+	}
+}
 
-		return res, nil
-	case lexer.TagComma:
-		err := p.expect(lexer.TagComma)
+// ELEM ::= ' ELEM | , ELEM | number | symbol | string | LIST, tok is the first token of the element.
+func (p *Parser) parseElem(tok *lexer.Token) (*ex.Expr, error) {
+	switch tok.Tag {
+	case lexer.TagQuote, lexer.TagComma:
+		next, err := p.lexer.NextToken()
 		if err != nil {
 			return nil, err
 		}
 
-		expr, err := p.parseElem()
+		expr, err := p.parseElem(next)
 		if err != nil {
 			return nil, err
 		}
 
-		expr.CalculatedForMacro = true
+		if tok.Tag == lexer.TagComma {
+			expr.CalculatedForMacro = true
+			return expr, nil
+		}
 
-		return expr, nil
-	case lexer.TagNumber:
-		res = ex.NewNumber(p.curToken.Number)
-	case lexer.TagSymbol:
-		res = ex.NewSymbol(p.curToken.String)
+		return quoted(expr)
+	case lexer.TagNumber, lexer.TagSymbol:
+		return atom(tok), nil
 	case lexer.TagLPar:
 		return p.parseList()
 	default:
-		return nil, NewParseErr(p.curToken.Tag, -1, "multi unexpected", p.curToken.Coords)
+		return nil, NewParseErr(tok.Tag, -1, "multi unexpected", tok.Coords)
 	}
+}
 
-	err := p.nextToken()
-	if err != nil {
-		return nil, err
+func list(items []*ex.Expr) (*ex.Expr, error) {
+	res := ex.NewNil()
+	for i := len(items) - 1; i >= 0; i-- {
+		if res = items[i].Cons(res); res.Type == ex.Fatal {
+			return nil, ex.NewExprError(res.String)
+		}
 	}
 
 	return res, nil
 }
 
-func (p *Parser) expect(expected int) error {
-	if p.curToken.Tag != expected {
-		return NewParseErr(p.curToken.Tag, expected, "unexpected", p.curToken.Coords)
-	}
+func atom(tok *lexer.Token) *ex.Expr {
+	switch tok.Tag {
+	case lexer.TagNumber:
+		return ex.NewNumber(tok.Number)
+	case lexer.TagSymbol:
+		if tok.IsString() {
+			return ex.NewString(tok.String)
+		}
 
-	return p.nextToken()
+		return ex.NewSymbol(tok.String)
+	default:
+		return ex.NewSymbol(tok.String)
+	}
 }
 
-func (p *Parser) nextToken() error {
-	curTok, err := p.lexer.NextToken()
-	if err != nil {
-		return err
+func quoted(expr *ex.Expr) (*ex.Expr, error) {
+	res := ex.NewFunction("quote").Cons(expr.ToList())
+	if res.Type == ex.Fatal {
+		return nil, ex.NewExprError(res.String)
 	}
 
-	p.curToken = curTok
-	return nil
+	return res, nil
+}
+
+// Incomplete reports whether the text is the beginning of a program, e.g. it has unclosed parentheses.
+func Incomplete(text string) bool {
+	_, err := NewParser(text).Parse()
+	pErr, ok := err.(*ParseError)
+	return ok && pErr.Got == lexer.TagEOF
 }
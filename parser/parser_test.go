@@ -1,8 +1,14 @@
 package parser
 
 import (
+	"bufio"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
 	"testing"
 
+	ex "github.com/batrSens/LispXS/expressions"
 	"github.com/magiconair/properties/assert"
 )
 
@@ -19,3 +25,90 @@ func debugT(t *testing.T, text string) {
 
 	//fmt.Println(res.ToString())
 }
+
+func TestReader(t *testing.T) {
+	src := bufio.NewReader(strings.NewReader("(2) 3 ; comment\n(a\n b) c\n\n) d\nrest of input\nlast"))
+	rd := NewReader(src)
+
+	res, err := rd.ReadLine()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.ToString(), "((2) 3)")
+
+	res, err = rd.ReadLine()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.ToString(), "((a b) c)")
+
+	res, err = rd.ReadLine()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.ToString(), "nil")
+
+	_, err = rd.ReadLine()
+	assert.Equal(t, err != nil, true)
+
+	line, _ := src.ReadString('\n')
+	assert.Equal(t, line, "rest of input\n")
+
+	res, err = rd.ReadLine()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.ToString(), "(last)")
+
+	_, err = rd.ReadLine()
+	assert.Equal(t, err, io.EOF)
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 2000; i++ {
+		expr := randomExpr(rnd, 3)
+		text := expr.ReadableString()
+
+		res, err := NewParser(text).Parse()
+		assert.Equal(t, err, nil, text)
+		assert.Equal(t, res.Length(), 1, text)
+		assert.Equal(t, res.Car().Equal(expr), true, text)
+	}
+}
+
+func randomExpr(rnd *rand.Rand, depth int) *ex.Expr {
+	switch n := rnd.Intn(6); {
+	case n == 0:
+		return randomNumber(rnd)
+	case n <= 2 || depth == 0:
+		return ex.NewSymbol(randomSymbol(rnd))
+	case n == 3:
+		res, _ := quoted(randomExpr(rnd, depth-1))
+		return res
+	default:
+		res := ex.NewNil()
+		for i := rnd.Intn(4); i > 0; i-- {
+			res = randomExpr(rnd, depth-1).Cons(res)
+		}
+
+		return res
+	}
+}
+
+func randomNumber(rnd *rand.Rand) *ex.Expr {
+	switch rnd.Intn(4) {
+	case 0:
+		return ex.NewNumber(float64(rnd.Intn(2000) - 1000))
+	case 1:
+		return ex.NewNumber(rnd.NormFloat64())
+	case 2:
+		return ex.NewNumber(rnd.NormFloat64() * math.Pow10(rnd.Intn(60)-30))
+	default:
+		return ex.NewNumber(float64(rnd.Int63()))
+	}
+}
+
+func randomSymbol(rnd *rand.Rand) string {
+	chars := []rune("ab-+./|\\;'\",() \n\t0123456789eλ#:")
+
+	res := make([]rune, rnd.Intn(6))
+	for i := range res {
+		res[i] = chars[rnd.Intn(len(chars))]
+	}
+
+	return string(res)
+}
@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"io"
+
+	ex "github.com/batrSens/LispXS/expressions"
+	"github.com/batrSens/LispXS/lexer"
+)
+
+// Reader reads expressions from the stream. Characters are read only while an expression isn't finished,
+// so the rest of the stream is left to the next call or to other readers of the source.
+type Reader struct {
+	parser *Parser
+	lexer  *lexer.Lexer
+}
+
+func NewReader(src io.RuneScanner) *Reader {
+	lex := lexer.NewStreamLexer(src)
+	return &Reader{
+		parser: &Parser{lexer: lex},
+		lexer:  lex,
+	}
+}
+
+// ReadLine reads expressions up to the end of the line where the last of them is finished, expressions may
+// span several lines. io.EOF is returned if the stream is finished and there are no expressions.
+func (r *Reader) ReadLine() (*ex.Expr, error) {
+	var exprs []*ex.Expr
+	for !r.lexer.SkipLine() {
+		tok, err := r.lexer.NextToken()
+		if err != nil {
+			return nil, r.recover(err)
+		}
+
+		expr, err := r.parser.parseElem(tok)
+		if err != nil {
+			return nil, r.recover(err)
+		}
+
+		exprs = append(exprs, expr)
+	}
+
+	if err := r.lexer.Release(); err != nil {
+		return nil, err
+	}
+
+	if len(exprs) == 0 && r.lexer.Finished() {
+		return ex.NewNil(), io.EOF
+	}
+
+	return list(exprs)
+}
+
+// recover skips the rest of the line with the error, so the next call starts from the next line.
+func (r *Reader) recover(err error) error {
+	r.lexer.SkipRest()
+	_ = r.lexer.Release()
+	return err
+}
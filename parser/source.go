@@ -0,0 +1,159 @@
+package parser
+
+import (
+	"github.com/batrSens/LispXS/lexer"
+)
+
+const (
+	NodeSymbol = iota
+	NodeNumber
+	NodeString
+	NodeList
+	NodeQuote
+	NodeComma
+	NodeComment
+)
+
+// Node is an element of the source code with comments, it is used by tools that rewrite the source.
+// Symbols, numbers, strings and comments keep their text as it is written, a comment written on the line of the node after it
+// is kept in Comment of the node.
+type Node struct {
+	Kind    int
+	Text    string
+	Items   []*Node
+	Comment string
+
+	// Line and EndLine are the lines of the start and the end of the node.
+	Line, EndLine int
+}
+
+// SourceParser is the variant of Parser that keeps comments and the way expressions are written.
+type SourceParser struct {
+	curToken *lexer.Token
+	lexer    *lexer.Lexer
+}
+
+func NewSourceParser(text string) *SourceParser {
+	return &SourceParser{
+		lexer: lexer.NewCommentLexer(text),
+	}
+}
+
+// PROGRAM ::= INNER eof
+func (p *SourceParser) Parse() ([]*Node, error) {
+	err := p.nextToken()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := p.parseInner()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.curToken.Tag != lexer.TagEOF {
+		return nil, NewParseErr(p.curToken.Tag, lexer.TagEOF, "unexpected", p.curToken.Coords)
+	}
+
+	return nodes, nil
+}
+
+// INNER ::= ELEM INNER | comment INNER | .
+func (p *SourceParser) parseInner() ([]*Node, error) {
+	var nodes []*Node
+	for p.curToken.Tag != lexer.TagRPar && p.curToken.Tag != lexer.TagEOF {
+		if p.curToken.Tag == lexer.TagComment {
+			comment := p.curToken
+			if n := len(nodes); n > 0 && nodes[n-1].Kind != NodeComment && nodes[n-1].Comment == "" &&
+				nodes[n-1].EndLine == comment.Start.Line {
+				nodes[n-1].Comment = comment.String
+			} else {
+				nodes = append(nodes, &Node{
+					Kind:    NodeComment,
+					Text:    comment.String,
+					Line:    comment.Start.Line,
+					EndLine: comment.Start.Line,
+				})
+			}
+
+			if err := p.nextToken(); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		node, err := p.parseElem()
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+// ELEM ::= ' ELEM | , ELEM | number | symbol | string | ( INNER )
+func (p *SourceParser) parseElem() (*Node, error) {
+	tok := p.curToken
+	if err := p.nextToken(); err != nil {
+		return nil, err
+	}
+
+	switch tok.Tag {
+	case lexer.TagQuote, lexer.TagComma:
+		if p.curToken.Tag == lexer.TagComment {
+			return nil, NewParseErr(p.curToken.Tag, -1, "comment after quote", p.curToken.Coords)
+		}
+
+		elem, err := p.parseElem()
+		if err != nil {
+			return nil, err
+		}
+
+		kind := NodeQuote
+		if tok.Tag == lexer.TagComma {
+			kind = NodeComma
+		}
+
+		return &Node{Kind: kind, Text: tok.Text, Items: []*Node{elem}, Line: tok.Start.Line, EndLine: elem.EndLine}, nil
+	case lexer.TagSymbol:
+		kind := NodeSymbol
+		if tok.IsString() {
+			kind = NodeString
+		}
+
+		return &Node{Kind: kind, Text: tok.Text, Line: tok.Start.Line, EndLine: tok.Start.Line}, nil
+	case lexer.TagNumber:
+		return &Node{Kind: NodeNumber, Text: tok.Text, Line: tok.Start.Line, EndLine: tok.Start.Line}, nil
+	case lexer.TagLPar:
+		items, err := p.parseInner()
+		if err != nil {
+			return nil, err
+		}
+
+		end := p.curToken
+		if end.Tag != lexer.TagRPar {
+			return nil, NewParseErr(end.Tag, lexer.TagRPar, "unexpected", end.Coords)
+		}
+
+		if err := p.nextToken(); err != nil {
+			return nil, err
+		}
+
+		return &Node{Kind: NodeList, Items: items, Line: tok.Start.Line, EndLine: end.Start.Line}, nil
+	default:
+		return nil, NewParseErr(tok.Tag, -1, "multi unexpected", tok.Coords)
+	}
+}
+
+func (p *SourceParser) nextToken() error {
+	curTok, err := p.lexer.NextToken()
+	if err != nil {
+		return err
+	}
+
+	p.curToken = curTok
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	ex "github.com/batrSens/LispXS/expressions"
+	"github.com/batrSens/LispXS/interpreter"
+	"github.com/batrSens/LispXS/parser"
+)
+
+// resultWidth is the width of results printed by the REPL.
+const resultWidth = 80
+
+// repl reads programs from the terminal and prints results of their forms. It returns the exit code of
+// the process.
+func repl() int {
+	// the reader is shared by the line editor and read of the programs, so input isn't lost between them
+	stdin := bufio.NewReader(os.Stdin)
+
+	interp, err := interpreter.New(os.Stdout, os.Stderr, stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer interp.Close()
+
+	editor := newLineEditor(stdin, os.Stdout, int(os.Stdin.Fd()), historyFile())
+	editor.complete = completer(interp)
+
+	// Ctrl-C interrupts the calculation, the line editor reads it as a key
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+	go func() {
+		for range signals {
+			interp.Interrupt()
+		}
+	}()
+
+	if isTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintln(os.Stderr, "LispXS v0.2.5")
+	}
+
+	var src string
+	for {
+		prompt := "> "
+		if src != "" {
+			prompt = "... "
+		}
+
+		line, err := editor.readLine(prompt)
+		if err == errInterrupted {
+			src = ""
+			continue
+		} else if err == io.EOF {
+			return 0
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+
+		src += line + "\n"
+		if parser.Incomplete(src) {
+			continue
+		}
+
+		editor.addHistory(src)
+		interp.ResetInterrupt()
+		if interpreter.IsCommand(src) {
+			res, err := interp.Command(src, os.Stdout)
+			if err == interpreter.ErrQuit {
+				return 0
+			} else if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			} else if code, exit := stopped(res); exit {
+				return code
+			}
+		} else if code, exit := eval(interp, src); exit {
+			return code
+		}
+		src = ""
+	}
+}
+
+// eval calculates forms of the program one by one and prints their results. It returns true with the exit
+// code if the program is stopped by exit.
+func eval(interp *interpreter.Interpreter, src string) (int, bool) {
+	exprs, err := parser.NewParser(src).Parse()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "syntax error:", err)
+		return 0, false
+	}
+
+	for cur := exprs; cur.Type == ex.Pair; cur = cur.Cdr() {
+		res := interp.Eval(cur.Car().ToList())
+		if res.Type == ex.Fatal {
+			return stopped(res)
+		}
+
+		fmt.Println(ex.Pretty(res, resultWidth))
+	}
+
+	return 0, false
+}
+
+// stopped reports the error that stops the calculation. It returns true with the exit code if the program
+// is stopped by exit.
+func stopped(res *ex.Expr) (int, bool) {
+	switch {
+	case res == nil || res.Type != ex.Fatal:
+		return 0, false
+	case res.IsExit():
+		return interpreter.ExitCode(res), true
+	case res.String == interpreter.InterruptTag:
+		fmt.Fprintln(os.Stderr, "\nFATAL: "+interpreter.InterruptTag)
+	}
+
+	// other stack traces are printed by the interpreter
+	return 0, false
+}